@@ -1,69 +1,265 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"school-management/internal/api/handlers"
 	mw "school-management/internal/api/middlewares"
 	"school-management/internal/api/router"
+	"school-management/internal/config"
+	"school-management/internal/lifecycle"
 	"school-management/internal/repository/sqlconnect"
-
-	"github.com/joho/godotenv"
+	"school-management/internal/tracing"
+	"school-management/pkg/utils"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
+	printRoutes := flag.Bool("routes", false, "print the routing table and exit")
+	printConfig := flag.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
 
-	err := godotenv.Load()
+	if *printRoutes {
+		router.PrintRoutes(os.Stdout)
+		return
+	}
+	if *printConfig {
+		printErr := cfg.Print(os.Stdout)
+		if printErr != nil {
+			fmt.Fprintln(os.Stderr, "Error printing the config:", printErr)
+		}
+	}
 	if err != nil {
-		log.Println("Error-------", err)
+		fmt.Fprintf(os.Stderr, "Invalid config:\n%v\n", err)
+		os.Exit(1)
+	}
+	if *printConfig {
 		return
 	}
 
-	_, err = sqlconnect.ConnectDB()
+	// the standard log package writes through it as well
+	logger, err := utils.NewLogger(os.Stderr, cfg.Logging.Level, cfg.Logging.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating the logger:", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// spans of requests, repository calls and sql statements go to the configured exporter
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("error setting up tracing", "error", err)
+		return
+	}
+
+	err = sqlconnect.OpenPool(cfg.Database)
+	if err != nil {
+		slog.Error("error opening the database pool", "error", err)
+		return
+	}
 
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), time.Minute)
+	err = sqlconnect.Migrate(migrateCtx)
+	cancelMigrate()
 	if err != nil {
-		log.Println("Error-------", err)
+		slog.Error("error migrating the database", "error", err)
 		return
 	}
 
-	port := os.Getenv("API_PORT")
+	handlers.SetHealthConfig(cfg.Health)
+	handlers.SetAuthConfig(cfg.Auth)
 
-	// cert := "cert.pem"
-	// key := "key.pem"
+	port := cfg.Server.Addr
 
 	mux := router.MainRouter()
 
-	tlsConfig := &tls.Config{
-		MinVersion: tls.VersionTLS12,
+	// without tls the config is unused, the server is plain http (eg. behind a tls terminating proxy)
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		slog.Error("error configuring tls", "error", err)
+		return
 	}
 
-	// rl := mw.NewRateLimiter(5, time.Minute)
-
-	// hppOptions := mw.HPPOptions{
-	// 	CheckQuery:                  true,
-	// 	CheckBody:                   true,
-	// 	CheckBodyOnlyForContentType: "application/x-www-form-urlencoded",
-	// 	Whitelist:                   []string{"sortBy", "name", "age", "class"},
-	// }
-
-	// secureMux := mw.Cors(rl.Middleware(mw.ResponseTime(mw.SecurityHeaders(mw.Compression(mw.Hpp(hppOptions)(mux))))))
-	// function to properly chain middlewares
-	// secureMux := utils.ApplyMiddlewares(mux, mw.Hpp(hppOptions), mw.Compression, mw.SecurityHeaders, mw.ResponseTime, rl.Middleware, mw.Cors)
-	secureMux := mw.SecurityHeaders(mux)
+	// middlewares, their order and options come from the config (see config.example.yaml)
+	secureMux, err := mw.BuildPipeline(mux, mw.PipelineConfig{
+		Middlewares: cfg.Server.Middlewares,
+		Cors: mw.CorsOptions{
+			AllowedOrigins:   cfg.Cors.AllowedOrigins,
+			AllowedMethods:   cfg.Cors.AllowedMethods,
+			AllowedHeaders:   cfg.Cors.AllowedHeaders,
+			ExposedHeaders:   cfg.Cors.ExposedHeaders,
+			AllowCredentials: cfg.Cors.AllowCredentials,
+			MaxAge:           cfg.Cors.MaxAge,
+		},
+		Auth: mw.AuthOptions{
+			JWTSecret: cfg.Auth.JWTSecret,
+			APIKeys:   cfg.Auth.APIKeys,
+		},
+		RateLimit: rateLimiterOptions(cfg.RateLimit),
+		Hpp: mw.HPPOptions{
+			CheckQuery:                  cfg.Server.Hpp.CheckQuery,
+			CheckBody:                   cfg.Server.Hpp.CheckBody,
+			CheckBodyOnlyForContentType: cfg.Server.Hpp.CheckBodyOnlyForContentType,
+			CheckJSON:                   cfg.Server.Hpp.CheckJSON,
+			Whitelist:                   cfg.Server.Hpp.Whitelist,
+			Routes:                      router.QueryParamWhitelists(),
+			Repeatable:                  cfg.Server.Hpp.Repeatable,
+			Duplicates:                  cfg.Server.Hpp.Duplicates,
+			Strict:                      cfg.Server.Hpp.Strict,
+		},
+		Compression: mw.CompressionOptions{
+			MinSize:      cfg.Server.Compression.MinSize,
+			ContentTypes: cfg.Server.Compression.ContentTypes,
+		},
+		Metrics:   mw.MetricsOptions{Routes: router.RoutePatterns()},
+		Tracing:   mw.TracingOptions{Routes: router.RoutePatterns()},
+		BodyLimit: bodyLimitOptions(cfg.Server),
+		Idempotency: mw.IdempotencyOptions{
+			Routes:      router.IdempotentRoutes(),
+			TTL:         cfg.Idempotency.TTL,
+			LockTimeout: cfg.Idempotency.LockTimeout,
+			Store:       cfg.Idempotency.Store,
+		},
+		Caching: cachingOptions(cfg.Server.Caching),
+	})
+	if err != nil {
+		slog.Error("error building the middleware pipeline", "error", err)
+		return
+	}
 
 	//custom server
 	server := &http.Server{
-		Addr:      port,
-		Handler:   secureMux,
-		TLSConfig: tlsConfig,
+		Addr:              port,
+		Handler:           secureMux,
+		TLSConfig:         tlsConfig,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	servers := []*http.Server{server}
+	serverErr := make(chan error, 2)
+	go func() {
+		if cfg.TLS.Enabled {
+			slog.Info("server is running with tls", "addr", port)
+			// the certificate comes from tlsConfig.GetCertificate
+			serverErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("server is running", "addr", port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	if cfg.TLS.Enabled && cfg.TLS.RedirectAddr != "" {
+		redirectServer := &http.Server{
+			Addr:              cfg.TLS.RedirectAddr,
+			Handler:           httpsRedirect(port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		servers = append(servers, redirectServer)
+		go func() {
+			slog.Info("redirecting http to https", "addr", cfg.TLS.RedirectAddr)
+			serverErr <- redirectServer.ListenAndServe()
+		}()
 	}
 
-	fmt.Println("Server is running on port: ", port)
-	err = server.ListenAndServe()
-	// err := server.ListenAndServeTLS(cert, key)
+	select {
+	case err = <-serverErr:
+		slog.Error("error starting the server", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	// a second signal kills the process right away
+	stop()
+
+	shutdown(servers, cfg.Server, shutdownTracing)
+}
+
+// shutdown drains the server: readiness turns false, in-flight requests and background workers
+// get cfg.ShutdownTimeout to finish, then the db pool is closed and the remaining spans are flushed
+func shutdown(servers []*http.Server, cfg config.Server, shutdownTracing func(ctx context.Context) error) {
+	slog.Info("shutting down")
+	lifecycle.StartDrain()
+	time.Sleep(cfg.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := server.Shutdown(ctx)
+			if err != nil {
+				slog.Error("error draining requests, closing remaining connections", "error", err)
+				server.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := lifecycle.StopWorkers(ctx)
+	if err != nil {
+		slog.Error("error stopping background workers", "error", err)
+	}
+
+	err = sqlconnect.ClosePool()
+	if err != nil {
+		slog.Error("error closing the database pool", "error", err)
+	}
+
+	err = shutdownTracing(ctx)
 	if err != nil {
-		log.Fatalln("Error starting the server: ", err)
+		slog.Error("error flushing the traces", "error", err)
+	}
+	slog.Info("server stopped")
+}
+
+// bodyLimitOptions merges the configured body limits into the ones the routes declare
+func bodyLimitOptions(cfg config.Server) mw.BodyLimitOptions {
+	options := mw.BodyLimitOptions{Default: int64(cfg.MaxBodySize), Routes: router.BodyLimits()}
+	for pattern, limit := range cfg.BodyLimits {
+		options.Routes[pattern] = int64(limit)
+	}
+	return options
+}
+
+// cachingOptions merges the configured Cache-Control policies into the ones the routes declare
+func cachingOptions(cfg config.Caching) mw.CachingOptions {
+	options := mw.CachingOptions{Routes: router.CachePolicies(), MaxBodySize: cfg.MaxBodySize}
+	for pattern, policy := range cfg.Policies {
+		options.Routes[pattern] = policy
+	}
+	return options
+}
+
+func rateLimiterOptions(cfg config.RateLimit) mw.RateLimiterOptions {
+	policy := func(p config.RateLimitPolicy) mw.RateLimitPolicy {
+		return mw.RateLimitPolicy{Name: p.Name, Algorithm: p.Algorithm, Limit: p.Limit, Window: p.Window, Key: p.Key}
+	}
+
+	options := mw.RateLimiterOptions{
+		Default:        policy(cfg.RateLimitPolicy),
+		Routes:         make(map[string]mw.RateLimitPolicy),
+		TrustedProxies: cfg.TrustedProxies,
+		IdleTimeout:    cfg.IdleTimeout,
+		Store:          cfg.Store,
+	}
+	for pattern, routePolicy := range cfg.Routes {
+		options.Routes[pattern] = policy(routePolicy)
 	}
+	return options
 }
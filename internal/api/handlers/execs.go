@@ -8,8 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"school-management/internal/config"
 	"school-management/internal/models"
 	"school-management/internal/repository/sqlconnect"
 	"school-management/pkg/utils"
@@ -21,20 +21,20 @@ import (
 )
 
 func GetOneExecHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("getOneExecHandler:", r.URL)
+	utils.Logger(r.Context()).Debug("getOneExecHandler")
 
 	idStr := r.PathValue("id")
 
 	//Handle Path Parameter
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing id: %v", err), http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Error parsing id: %v", err))
 		return
 	}
 
-	exec, err := sqlconnect.GetExecByIdDbHandler(id)
+	exec, err := sqlconnect.GetExecByIdDbHandler(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -43,12 +43,26 @@ func GetOneExecHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetExecsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("getExecsHandler:", r.URL)
+	utils.Logger(r.Context()).Debug("getExecsHandler")
+
+	format, err := exportFormat(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if format != formatJSON {
+		exporter := newRowExporter(w, format, "execs", execExportColumns)
+		err = sqlconnect.StreamExecsDbHandler(r, func(row models.Exec) error {
+			return exporter.WriteRow(row)
+		})
+		finishExport(w, r, exporter, err)
+		return
+	}
 
 	var execs []models.Exec
-	execs, err := sqlconnect.GetExecsDbHandler(execs, r)
+	execs, err = sqlconnect.GetExecsDbHandler(execs, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	response := struct {
@@ -65,12 +79,17 @@ func GetExecsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func AddExecsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("postExecsHandler:", r.URL, r.Body)
+	utils.Logger(r.Context()).Debug("addExecsHandler")
+
+	atomic, err := isAtomicRequest(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	reqBody, err := io.ReadAll(r.Body) // store req body as it gets wiped out on reading once only
-	fmt.Println(string(reqBody))
 	if err != nil {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid Request Body")
 		return
 	}
 	defer r.Body.Close()
@@ -80,7 +99,18 @@ func AddExecsHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(reqBody, &rawExecs)
 	if err != nil {
-		http.Error(w, "Invalid Request Bodyas", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Bodyas")
+		return
+	}
+
+	// ?atomic=false - insert what we can and report the outcome per item
+	if !atomic {
+		err = json.Unmarshal(reqBody, &newExecs)
+		if err != nil {
+			utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Body")
+			return
+		}
+		addExecsPartial(w, r, rawExecs, newExecs)
 		return
 	}
 
@@ -95,7 +125,7 @@ func AddExecsHandler(w http.ResponseWriter, r *http.Request) {
 		for key := range exec {
 			_, ok := allowedFields[key]
 			if !ok {
-				http.Error(w, "Unacceptable field found in request. Only use allowed fields.", http.StatusBadRequest)
+				utils.WriteProblem(w, r, http.StatusBadRequest, "Unacceptable field found in request. Only use allowed fields.")
 				return
 			}
 		}
@@ -103,25 +133,29 @@ func AddExecsHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(reqBody, &newExecs)
 	if err != nil {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Body")
 		return
 	}
 
 	for _, exec := range newExecs {
 		err = CheckBlankFields(exec)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 	}
 
 	var addedExecs []models.Exec
 
-	addedExecs, err = sqlconnect.AddExecsDbHandler(addedExecs, newExecs)
+	addedExecs, err = sqlconnect.AddExecsDbHandler(r.Context(), addedExecs, newExecs)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
+	// the password hashes stay in the database, the idempotency store would otherwise keep them for its whole ttl
+	for i := range addedExecs {
+		addedExecs[i].Password = ""
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -140,27 +174,34 @@ func AddExecsHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// PATCH /execs/{id} - PATCH only updated the given fields
+// PATCH /execs/{id} - a merge patch (RFC 7396) or a json patch (RFC 6902), see decodePatch
 func PatchOneExecHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("patch Execss handler:", r.URL)
+	utils.Logger(r.Context()).Debug("patchOneExecHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid Exec id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Exec id")
 		return
 	}
 
-	var updates map[string]string
-	err = json.NewDecoder(r.Body).Decode(&updates)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid request body")
+		return
+	}
+	patch, err := decodePatch(r, body)
+	if err != nil {
+		writePatchError(w, r, err)
 		return
 	}
 
-	updatedExec, err := sqlconnect.PatchExecByIdDbHandler(id, updates)
+	// the patch is applied to the locked row, a failing test op or an invalid result leaves the exec as it was
+	updatedExec, err := sqlconnect.PatchExecByIdDbHandler(r.Context(), id, func(exec *models.Exec) error {
+		return patchModel(exec, execPatchFields, patch)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writePatchError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -172,14 +213,14 @@ func PatchExecsHandler(w http.ResponseWriter, r *http.Request) {
 	var updates []map[string]interface{}
 	err := json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
-		log.Printf("error decoding: %s", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		utils.Logger(r.Context()).Warn("error decoding request body", "error", err)
+		bodyError(w, r, err, "Invalid request payload")
 		return
 	}
 
-	err = sqlconnect.PatchExecsDbHandler(updates)
+	err = sqlconnect.PatchExecsDbHandler(r.Context(), updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -188,19 +229,19 @@ func PatchExecsHandler(w http.ResponseWriter, r *http.Request) {
 
 // DELETE /execs/{id}
 func DeleteOneExecHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("delete Exec handler:", r.URL)
+	utils.Logger(r.Context()).Debug("deleteOneExecHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid Exec id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Exec id")
 		return
 	}
 
-	err = sqlconnect.DeleteExecByIdDbHandler(id)
+	err = sqlconnect.DeleteExecByIdDbHandler(r.Context(), id)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -219,20 +260,29 @@ func DeleteOneExecHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// authConfig signs the login tokens, it is set by the server from its config
+var authConfig = config.Default().Auth
+
+func SetAuthConfig(cfg config.Auth) {
+	authConfig = cfg
+}
+
 func ExecsLoginHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Login handler")
+	utils.Logger(r.Context()).Debug("execsLoginHandler")
 	var req models.Exec
 
 	// Data Validation
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid req body", http.StatusBadRequest)
+		loginFailures.With(loginInvalidRequest).Inc()
+		bodyError(w, r, err, "Invalid req body")
 		return
 	}
 	defer r.Body.Close()
 
 	if req.Username == "" || req.Password == "" {
-		http.Error(w, "Username and password are required", http.StatusBadRequest)
+		loginFailures.With(loginInvalidRequest).Inc()
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Username and password are required")
 		return
 	}
 
@@ -240,36 +290,39 @@ func ExecsLoginHandler(w http.ResponseWriter, r *http.Request) {
 	db, err := sqlconnect.ConnectDB()
 	if err != nil {
 		// utils.ErrorHandler(err, "error updating data")
-		http.Error(w, "Error updating data", http.StatusBadRequest)
+		loginFailures.With(loginError).Inc()
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Error updating data")
 		return
 	}
-	defer db.Close()
 
 	var user models.Exec
-	err = db.QueryRow(`SELECT id, first_name, last_name, username, password, inactive_status, role FROM execs WHERE username = ?`, req.Username).Scan(&user.ID, &user.FirstName, &user.FirstName, &user.LastName, &user.Password, &user.InactiveStatus, &user.Role)
+	err = db.QueryRowContext(r.Context(), `SELECT id, first_name, last_name, username, password, inactive_status, role FROM execs WHERE username = ?`, req.Username).Scan(&user.ID, &user.FirstName, &user.FirstName, &user.LastName, &user.Password, &user.InactiveStatus, &user.Role)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			utils.ErrorHandler(err, "user not found")
-			fmt.Println(err)
-			http.Error(w, "user not found", http.StatusBadRequest)
+			utils.ErrorHandler(r.Context(), err, "user not found")
+			loginFailures.With(loginUnknownUser).Inc()
+			utils.WriteProblem(w, r, http.StatusBadRequest, "user not found")
 			return
 		}
-		fmt.Println(err)
-		http.Error(w, "database query error", http.StatusBadRequest)
+		utils.ErrorHandler(r.Context(), err, "database query error")
+		loginFailures.With(loginError).Inc()
+		utils.WriteProblem(w, r, http.StatusBadRequest, "database query error")
 		return
 	}
 
 	// check if user is active
 	if user.InactiveStatus {
-		http.Error(w, "Account in inactive", http.StatusForbidden)
+		loginFailures.With(loginInactive).Inc()
+		utils.WriteProblem(w, r, http.StatusForbidden, "Account in inactive")
 		return
 	}
 
 	// verify password
 	parts := strings.Split(user.Password, ".")
 	if len(parts) != 2 {
-		utils.ErrorHandler(errors.New("invalid encoded hash format"), "invalid encoded hash format")
-		http.Error(w, "invalid encoded hash format", http.StatusForbidden)
+		utils.ErrorHandler(r.Context(), errors.New("invalid encoded hash format"), "invalid encoded hash format")
+		loginFailures.With(loginError).Inc()
+		utils.WriteProblem(w, r, http.StatusForbidden, "invalid encoded hash format")
 		return
 	}
 
@@ -278,34 +331,52 @@ func ExecsLoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	salt, err := base64.StdEncoding.DecodeString(saltBase64)
 	if err != nil {
-		utils.ErrorHandler(err, "invalid encoded hash format")
-		http.Error(w, "invalid encoded hash format", http.StatusForbidden)
+		utils.ErrorHandler(r.Context(), err, "invalid encoded hash format")
+		loginFailures.With(loginError).Inc()
+		utils.WriteProblem(w, r, http.StatusForbidden, "invalid encoded hash format")
 		return
 	}
 
 	hashedPassword, err := base64.StdEncoding.DecodeString(hashedPasswrodBase64)
 	if err != nil {
-		utils.ErrorHandler(err, "failed to decode the hased password")
-		http.Error(w, "invalid encoded hash format", http.StatusForbidden)
+		utils.ErrorHandler(r.Context(), err, "failed to decode the hased password")
+		loginFailures.With(loginError).Inc()
+		utils.WriteProblem(w, r, http.StatusForbidden, "invalid encoded hash format")
 		return
 	}
 
 	hash := argon2.IDKey([]byte(req.Password), salt, 1, 64*1024, 4, 32)
 
 	if len(hash) != len(hashedPassword) {
-		utils.ErrorHandler(errors.New("incorrect password"), "incorrect password")
-		http.Error(w, "incorrect password", http.StatusForbidden)
+		utils.ErrorHandler(r.Context(), errors.New("incorrect password"), "incorrect password")
+		loginFailures.With(loginWrongPassword).Inc()
+		utils.WriteProblem(w, r, http.StatusForbidden, "incorrect password")
 		return
 	}
 
 	if subtle.ConstantTimeCompare(hash, hashedPassword) != 1 {
-		utils.ErrorHandler(errors.New("incorrect password"), "incorrect password")
-		http.Error(w, "incorrect password", http.StatusForbidden)
+		utils.ErrorHandler(r.Context(), errors.New("incorrect password"), "incorrect password")
+		loginFailures.With(loginWrongPassword).Inc()
+		utils.WriteProblem(w, r, http.StatusForbidden, "incorrect password")
 		return
 	}
 
 	// generate token
-	tokenString := "abc"
+	now := time.Now()
+	expiresAt := now.Add(authConfig.TokenTTL)
+	tokenString, err := utils.SignToken(utils.TokenClaims{
+		Subject:   strconv.Itoa(user.ID),
+		Username:  req.Username,
+		Role:      user.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}, []byte(authConfig.JWTSecret))
+	if err != nil {
+		utils.ErrorHandler(r.Context(), err, "error signing the token")
+		loginFailures.With(loginError).Inc()
+		utils.WriteProblem(w, r, http.StatusInternalServerError, "error signing the token")
+		return
+	}
 
 	// send token as a response or as a cookie
 	http.SetCookie(w, &http.Cookie{
@@ -314,7 +385,7 @@ func ExecsLoginHandler(w http.ResponseWriter, r *http.Request) {
 		Path:     "/",
 		Secure:   true,
 		HttpOnly: true,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  expiresAt,
 	})
 
 	http.SetCookie(w, &http.Cookie{
@@ -335,3 +406,37 @@ func ExecsLoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(response)
 }
+
+// addExecsPartial validates and inserts every exec on its own and responds with 207 Multi-Status
+func addExecsPartial(w http.ResponseWriter, r *http.Request, rawExecs []map[string]interface{}, newExecs []models.Exec) {
+	results := make([]models.BulkItemResult, len(newExecs))
+
+	var validExecs []models.Exec
+	var validIndexes []int
+	for i, exec := range newExecs {
+		err := checkAllowedFields(rawExecs[i], models.Exec{})
+		if err == nil {
+			err = CheckBlankFields(exec)
+		}
+		if err != nil {
+			results[i] = models.BulkItemResult{Index: i, Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		validExecs = append(validExecs, exec)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(validExecs) > 0 {
+		dbResults, err := sqlconnect.AddExecsPartialDbHandler(r.Context(), validExecs)
+		if err != nil {
+			utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for j, result := range dbResults {
+			result.Index = validIndexes[j]
+			results[validIndexes[j]] = result
+		}
+	}
+
+	writeBulkResults(w, results)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"school-management/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+	formatXLSX   = "xlsx"
+
+	exportFlushInterval = 100 // rows between flushes to the client
+)
+
+var exportContentTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatCSV:    "text/csv; charset=utf-8",
+	formatNDJSON: "application/x-ndjson",
+	formatXLSX:   xlsxContentType,
+}
+
+// columns of the list endpoints in csv/xlsx exports
+var (
+	studentExportColumns = []string{"id", "first_name", "last_name", "email", "class"}
+	teacherExportColumns = []string{"id", "first_name", "last_name", "email", "class", "subject"}
+	execExportColumns    = []string{"id", "first_name", "last_name", "email", "username"}
+)
+
+// exportFormat picks the response format of list endpoints, ?format= wins over the Accept header, json is the default
+func exportFormat(r *http.Request) (string, error) {
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		if _, ok := exportContentTypes[format]; !ok {
+			return "", errors.New("Invalid format, use json, csv, ndjson or xlsx")
+		}
+		return format, nil
+	}
+
+	best, bestQ := formatJSON, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if qParam, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qParam, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		var format string
+		switch mediaType {
+		case "application/json":
+			format = formatJSON
+		case "text/csv":
+			format = formatCSV
+		case "application/x-ndjson":
+			format = formatNDJSON
+		case xlsxContentType:
+			format = formatXLSX
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, nil
+}
+
+// rowExporter streams models as csv, ndjson or xlsx. Headers are only sent with the first row (or on Close),
+// so a failing query can still be answered with a proper error status.
+type rowExporter struct {
+	w        http.ResponseWriter
+	format   string
+	fileName string
+	columns  []string
+	started  bool
+	rows     int
+
+	csvWriter   *csv.Writer
+	jsonEncoder *json.Encoder
+	xlsxWriter  *utils.XLSXStreamWriter
+}
+
+func newRowExporter(w http.ResponseWriter, format, name string, columns []string) *rowExporter {
+	return &rowExporter{
+		w:        w,
+		format:   format,
+		fileName: fmt.Sprintf("%s.%s", name, format),
+		columns:  columns,
+	}
+}
+
+func (e *rowExporter) start() error {
+	e.started = true
+
+	e.w.Header().Set("Content-Type", exportContentTypes[e.format])
+	if e.format != formatNDJSON {
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, e.fileName))
+	}
+	e.w.WriteHeader(http.StatusOK)
+
+	var err error
+	switch e.format {
+	case formatCSV:
+		e.csvWriter = csv.NewWriter(e.w)
+		err = e.csvWriter.Write(e.columns)
+	case formatNDJSON:
+		e.jsonEncoder = json.NewEncoder(e.w)
+	case formatXLSX:
+		e.xlsxWriter, err = utils.NewXLSXStreamWriter(e.w, strings.TrimSuffix(e.fileName, "."+e.format))
+		if err == nil {
+			err = e.xlsxWriter.WriteRow(e.columns)
+		}
+	}
+	return err
+}
+
+func (e *rowExporter) WriteRow(model interface{}) error {
+	if !e.started {
+		err := e.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch e.format {
+	case formatCSV:
+		err = e.csvWriter.Write(neutralizeFormulas(exportValues(model, e.columns)))
+	case formatNDJSON:
+		err = e.jsonEncoder.Encode(model)
+	case formatXLSX:
+		err = e.xlsxWriter.WriteRow(neutralizeFormulas(exportValues(model, e.columns)))
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushInterval == 0 {
+		e.flush()
+	}
+	return nil
+}
+
+func (e *rowExporter) flush() {
+	switch e.format {
+	case formatCSV:
+		e.csvWriter.Flush()
+	case formatXLSX:
+		e.xlsxWriter.Flush()
+	}
+	http.NewResponseController(e.w).Flush()
+}
+
+func (e *rowExporter) Close() error {
+	if !e.started {
+		err := e.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	switch e.format {
+	case formatCSV:
+		e.csvWriter.Flush()
+		return e.csvWriter.Error()
+	case formatXLSX:
+		return e.xlsxWriter.Close()
+	}
+	return nil
+}
+
+// Started reports whether the response status and headers have already been sent
+func (e *rowExporter) Started() bool {
+	return e.started
+}
+
+// exportValues returns the values of the given json fields of the model as strings
+func exportValues(model interface{}, columns []string) []string {
+	modelVal := reflect.ValueOf(model)
+	modelType := modelVal.Type()
+
+	fieldIndex := make(map[string]int)
+	for i := 0; i < modelType.NumField(); i++ {
+		fieldIndex[strings.TrimSuffix(modelType.Field(i).Tag.Get("json"), ",omitempty")] = i
+	}
+
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		idx, ok := fieldIndex[column]
+		if ok {
+			values[i] = fmt.Sprint(modelVal.Field(idx).Interface())
+		}
+	}
+	return values
+}
+
+// neutralizeFormulas prefixes cells a spreadsheet would run as a formula with a quote (OWASP CSV injection),
+// eg. a student named "=HYPERLINK(...)" is shown as text instead of a link to click
+func neutralizeFormulas(values []string) []string {
+	for i, value := range values {
+		if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+			values[i] = "'" + value
+		}
+	}
+	return values
+}
+
+// finishExport ends an export, if streaming already started the status can't change anymore so the error is only logged
+func finishExport(w http.ResponseWriter, r *http.Request, exporter *rowExporter, err error) {
+	if err != nil {
+		if !exporter.Started() {
+			utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.Logger(r.Context()).Error("error streaming export", "error", err)
+		return
+	}
+
+	err = exporter.Close()
+	if err != nil {
+		utils.Logger(r.Context()).Error("error finishing export", "error", err)
+	}
+}
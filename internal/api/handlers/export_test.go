@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"school-management/internal/models"
+	"testing"
+)
+
+func TestCSVExportNeutralizesFormulas(t *testing.T) {
+	w := httptest.NewRecorder()
+	exporter := newRowExporter(w, formatCSV, "students", studentExportColumns)
+	students := []models.Student{
+		{ID: 1, FirstName: "=HYPERLINK(\"http://evil.example\")", LastName: "+1", Email: "@a.b", Class: "-9A"},
+		{ID: 2, FirstName: "Ada", LastName: "Lovelace", Email: "ada@school.com", Class: "9=A"},
+	}
+	for _, student := range students {
+		if err := exporter.WriteRow(student); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "id,first_name,last_name,email,class\n" +
+		"1,\"'=HYPERLINK(\"\"http://evil.example\"\")\",'+1,'@a.b,'-9A\n" +
+		"2,Ada,Lovelace,ada@school.com,9=A\n"
+	if got := w.Body.String(); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
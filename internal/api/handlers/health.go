@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"school-management/internal/config"
+	"school-management/internal/lifecycle"
+	"school-management/internal/repository/sqlconnect"
+	"school-management/pkg/utils"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthConfig is set by the server from its config
+var healthConfig = config.Default().Health
+
+func SetHealthConfig(cfg config.Health) {
+	healthConfig = cfg
+}
+
+// readinessChecks are the dependencies the api needs to serve requests, each runs with health.check_timeout
+var readinessChecks = []struct {
+	Name  string
+	Check func(ctx context.Context) error
+}{
+	{Name: "database", Check: sqlconnect.PingDB},
+	{Name: "migrations", Check: sqlconnect.CheckMigrations},
+}
+
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// runReadinessChecks runs the checks concurrently and reports whether all of them passed
+func runReadinessChecks(ctx context.Context) ([]checkResult, bool) {
+	results := make([]checkResult, len(readinessChecks))
+
+	var wg sync.WaitGroup
+	for i, check := range readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthConfig.CheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(checkCtx)
+			results[i] = checkResult{Name: check.Name, Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status = "failing"
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, result := range results {
+		healthy = healthy && result.Error == ""
+	}
+	return results, healthy
+}
+
+func writeHealth(w http.ResponseWriter, code int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GET /healthz
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// GET /readyz
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	type check struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	}
+	response := struct {
+		Status string  `json:"status"`
+		Checks []check `json:"checks,omitempty"`
+	}{Status: "ready"}
+
+	if lifecycle.Draining() {
+		response.Status = "draining"
+		writeHealth(w, http.StatusServiceUnavailable, response)
+		return
+	}
+
+	// errors are only reported by /health/details
+	results, healthy := runReadinessChecks(r.Context())
+	for _, result := range results {
+		response.Checks = append(response.Checks, check{Name: result.Name, Status: result.Status})
+	}
+	if !healthy {
+		response.Status = "not ready"
+		writeHealth(w, http.StatusServiceUnavailable, response)
+		return
+	}
+	writeHealth(w, http.StatusOK, response)
+}
+
+// GET /health/details
+func HealthDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Logger(r.Context()).Debug("healthDetailsHandler")
+
+	if healthConfig.AdminToken == "" && len(healthConfig.TrustedClients) == 0 {
+		utils.WriteProblem(w, r, http.StatusForbidden, "Health details are disabled")
+		return
+	}
+	if !healthDetailsAllowed(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		utils.WriteProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	results, healthy := runReadinessChecks(r.Context())
+
+	type poolStats struct {
+		MaxOpenConnections int     `json:"max_open_connections"`
+		OpenConnections    int     `json:"open_connections"`
+		InUse              int     `json:"in_use"`
+		Idle               int     `json:"idle"`
+		WaitCount          int64   `json:"wait_count"`
+		WaitDurationMs     float64 `json:"wait_duration_ms"`
+		MaxIdleClosed      int64   `json:"max_idle_closed"`
+		MaxIdleTimeClosed  int64   `json:"max_idle_time_closed"`
+		MaxLifetimeClosed  int64   `json:"max_lifetime_closed"`
+	}
+	response := struct {
+		Status       string        `json:"status"`
+		Version      string        `json:"version"`
+		Commit       string        `json:"commit,omitempty"`
+		GoVersion    string        `json:"go_version"`
+		StartedAt    time.Time     `json:"started_at"`
+		Uptime       string        `json:"uptime"`
+		Draining     bool          `json:"draining"`
+		Goroutines   int           `json:"goroutines"`
+		Checks       []checkResult `json:"checks"`
+		DatabasePool *poolStats    `json:"database_pool,omitempty"`
+	}{
+		Status:     "ok",
+		Version:    lifecycle.Version,
+		Commit:     vcsRevision(),
+		GoVersion:  runtime.Version(),
+		StartedAt:  lifecycle.StartedAt.UTC(),
+		Uptime:     time.Since(lifecycle.StartedAt).Round(time.Second).String(),
+		Draining:   lifecycle.Draining(),
+		Goroutines: runtime.NumGoroutine(),
+		Checks:     results,
+	}
+	if !healthy || response.Draining {
+		response.Status = "degraded"
+	}
+
+	stats, err := sqlconnect.PoolStats()
+	if err == nil {
+		response.DatabasePool = &poolStats{
+			MaxOpenConnections: stats.MaxOpenConnections,
+			OpenConnections:    stats.OpenConnections,
+			InUse:              stats.InUse,
+			Idle:               stats.Idle,
+			WaitCount:          stats.WaitCount,
+			WaitDurationMs:     float64(stats.WaitDuration.Microseconds()) / 1000,
+			MaxIdleClosed:      stats.MaxIdleClosed,
+			MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+		}
+	}
+
+	// the details are informational, the status code only says the request was served
+	writeHealth(w, http.StatusOK, response)
+}
+
+// healthDetailsAllowed accepts the admin token or the verified client certificate of a trusted internal service
+func healthDetailsAllowed(r *http.Request) bool {
+	if name, ok := utils.VerifiedClientName(r); ok && slices.Contains(healthConfig.TrustedClients, name) {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && healthConfig.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(healthConfig.AdminToken)) == 1
+}
+
+func vcsRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"school-management/internal/config"
+	"testing"
+)
+
+func TestHealthDetailsAccess(t *testing.T) {
+	defer SetHealthConfig(healthConfig)
+	SetHealthConfig(config.Health{CheckTimeout: 1, AdminToken: "admin-token", TrustedClients: []string{"prometheus"}})
+
+	withClientCert := func(name string) func(r *http.Request) {
+		return func(r *http.Request) {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+	}
+	tests := []struct {
+		name     string
+		setup    func(r *http.Request)
+		expected int
+	}{
+		{"anonymous", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"admin token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer admin-token") }, http.StatusOK},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"trusted client", withClientCert("prometheus"), http.StatusOK},
+		{"other client", withClientCert("someone-else"), http.StatusUnauthorized},
+		{"unverified certificate", func(r *http.Request) { r.TLS = &tls.ConnectionState{} }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/health/details", nil)
+		tt.setup(r)
+		w := httptest.NewRecorder()
+		HealthDetailsHandler(w, r)
+		if w.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.expected, w.Code)
+		}
+	}
+}
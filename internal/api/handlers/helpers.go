@@ -1,18 +1,28 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"reflect"
+	"school-management/internal/models"
 	"school-management/pkg/utils"
+	"strconv"
 	"strings"
 )
 
+// RouteURL builds the path of a named route, it is set by the router (handlers can't import it)
+var RouteURL = func(name string, params ...string) (string, error) {
+	return "", errors.New("routes are not registered")
+}
+
 func CheckBlankFields(value interface{}) error {
 	val := reflect.ValueOf(value)
 	for i := 0; i < val.NumField(); i++ {
 		field := val.Field(i)
 		if field.Kind() == reflect.String && field.String() == "" {
-			return utils.ErrorHandler(errors.New("All fields are required"), "All fields are required")
+			return errors.New("All fields are required")
 		}
 	}
 	return nil
@@ -29,3 +39,90 @@ func GetFieldNames(model interface{}) []string {
 	}
 	return fields
 }
+
+// errorStatusCode maps repository errors to http status codes (404 for unknown rows, 409 for unique constraint
+// conflicts, 500 otherwise)
+func errorStatusCode(err error) int {
+	var conflictErr *utils.ConflictError
+	if errors.As(err, &conflictErr) {
+		return http.StatusConflict
+	}
+	var notFoundErr *utils.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// isBodyTooLarge reports whether reading the body failed on the route's body limit (see the body_limit middleware)
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// bodyError answers a body that couldn't be read or decoded, 413 if it exceeded the body limit and 400 with message otherwise
+func bodyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		utils.WriteProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("The request body exceeds the limit of %d bytes", maxBytesErr.Limit))
+		return
+	}
+	utils.WriteProblem(w, r, http.StatusBadRequest, message)
+}
+
+// isAtomicRequest reads the ?atomic= query param of bulk endpoints, bulk writes are all or nothing unless atomic=false
+func isAtomicRequest(r *http.Request) (bool, error) {
+	atomicParam := r.URL.Query().Get("atomic")
+	if atomicParam == "" {
+		return true, nil
+	}
+	atomic, err := strconv.ParseBool(atomicParam)
+	if err != nil {
+		return false, errors.New("Invalid value for atomic, use true or false")
+	}
+	return atomic, nil
+}
+
+// checkAllowedFields rejects keys of a raw request item which are not json fields of the model
+func checkAllowedFields(rawItem map[string]interface{}, model interface{}) error {
+	allowedFields := make(map[string]struct{})
+	for _, field := range GetFieldNames(model) {
+		allowedFields[field] = struct{}{}
+	}
+
+	for key := range rawItem {
+		_, ok := allowedFields[key]
+		if !ok {
+			return errors.New("Unacceptable field found in request. Only use allowed fields.")
+		}
+	}
+	return nil
+}
+
+// writeBulkResults sends the per item outcome of a non-atomic bulk request as 207 Multi-Status
+func writeBulkResults(w http.ResponseWriter, results []models.BulkItemResult) {
+	succeeded := 0
+	for _, result := range results {
+		if result.Status >= 200 && result.Status < 300 {
+			succeeded++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMultiStatus)
+
+	response := struct {
+		Status    string                  `json:"status"`
+		Count     int                     `json:"count"`
+		Succeeded int                     `json:"succeeded"`
+		Failed    int                     `json:"failed"`
+		Results   []models.BulkItemResult `json:"results"`
+	}{
+		Status:    "multi-status",
+		Count:     len(results),
+		Succeeded: succeeded,
+		Failed:    len(results) - succeeded,
+		Results:   results,
+	}
+	json.NewEncoder(w).Encode(response)
+}
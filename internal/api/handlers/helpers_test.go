@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"school-management/pkg/utils"
+	"testing"
+)
+
+func TestErrorStatusCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{&utils.NotFoundError{Entity: "Import job"}, http.StatusNotFound},
+		{fmt.Errorf("patching: %w", &utils.NotFoundError{Entity: "Student"}), http.StatusNotFound},
+		{&utils.ConflictError{Entity: "teacher", Field: "email", Value: "a@b.c"}, http.StatusConflict},
+		{errors.New("Error connecting to DB"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if code := errorStatusCode(tt.err); code != tt.expected {
+			t.Errorf("%v: expected %d, got %d", tt.err, tt.expected, code)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"school-management/internal/lifecycle"
+	"school-management/internal/models"
+	"school-management/internal/repository/sqlconnect"
+	"school-management/pkg/utils"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("school-management/internal/api/handlers")
+
+const (
+	MaxImportFileSize      = 32 << 20 // 32 MB, the import routes have it as their body limit
+	importProgressInterval = 50       // rows between progress updates of the import job
+	xlsxContentType        = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// importRow is one data row of an uploaded file mapped to the json fields of the model
+type importRow struct {
+	Row    int // spreadsheet row number, header is row 1
+	Values map[string]string
+}
+
+type importFile struct {
+	FileName       string
+	TotalRows      int
+	Rows           []importRow // valid rows only
+	IgnoredColumns []string
+	Errors         []models.ImportRowError
+}
+
+// POST /students/import
+func ImportStudentsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Logger(r.Context()).Debug("importStudentsHandler")
+
+	file, err := prepareImport(r, models.Student{})
+	if err != nil {
+		bodyError(w, r, err, err.Error())
+		return
+	}
+
+	dryRun, err := isDryRun(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dryRun {
+		writeDryRunReport(w, file)
+		return
+	}
+
+	students := make([]models.Student, len(file.Rows))
+	for i, row := range file.Rows {
+		setModelFields(&students[i], row.Values)
+	}
+
+	startImportJob(w, r, "student", file, func(ctx context.Context, onRow func(index int, id int, created bool, err error)) error {
+		return sqlconnect.ImportStudentsDbHandler(ctx, students, onRow)
+	})
+}
+
+// POST /teachers/import
+func ImportTeachersHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Logger(r.Context()).Debug("importTeachersHandler")
+
+	file, err := prepareImport(r, models.Teacher{})
+	if err != nil {
+		bodyError(w, r, err, err.Error())
+		return
+	}
+
+	dryRun, err := isDryRun(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dryRun {
+		writeDryRunReport(w, file)
+		return
+	}
+
+	teachers := make([]models.Teacher, len(file.Rows))
+	for i, row := range file.Rows {
+		setModelFields(&teachers[i], row.Values)
+	}
+
+	startImportJob(w, r, "teacher", file, func(ctx context.Context, onRow func(index int, id int, created bool, err error)) error {
+		return sqlconnect.ImportTeachersDbHandler(ctx, teachers, onRow)
+	})
+}
+
+// GET /imports/{id}
+func GetImportJobHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Logger(r.Context()).Debug("getImportJobHandler")
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid import job id")
+		return
+	}
+
+	job, err := sqlconnect.GetImportJobByIdDbHandler(r.Context(), id)
+	if err != nil {
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// prepareImport reads the uploaded file, maps its header to the model fields and validates every row
+func prepareImport(r *http.Request, model interface{}) (importFile, error) {
+	fileName, records, err := readImportFile(r)
+	if err != nil {
+		return importFile{}, err
+	}
+	if len(records) == 0 {
+		return importFile{}, errors.New("Uploaded file is empty")
+	}
+
+	mapping, err := parseColumnMapping(r)
+	if err != nil {
+		return importFile{}, err
+	}
+
+	columns, ignored, err := mapImportColumns(records[0], model, mapping)
+	if err != nil {
+		return importFile{}, err
+	}
+
+	file := importFile{FileName: fileName, IgnoredColumns: ignored, Errors: []models.ImportRowError{}}
+	seenEmails := make(map[string]int)
+
+	for i, record := range records[1:] {
+		rowNumber := i + 2
+		if isBlankRecord(record) {
+			continue
+		}
+		file.TotalRows++
+
+		values := make(map[string]string)
+		for col, field := range columns {
+			if col < len(record) {
+				values[field] = strings.TrimSpace(record[col])
+			}
+		}
+
+		rowErrors := validateImportRow(rowNumber, values, model)
+		if email := strings.ToLower(values["email"]); email != "" {
+			if firstRow, ok := seenEmails[email]; ok {
+				rowErrors = append(rowErrors, models.ImportRowError{Row: rowNumber, Field: "email", Error: fmt.Sprintf("duplicate email, already used in row %d", firstRow)})
+			} else {
+				seenEmails[email] = rowNumber
+			}
+		}
+
+		if len(rowErrors) > 0 {
+			file.Errors = append(file.Errors, rowErrors...)
+			continue
+		}
+		file.Rows = append(file.Rows, importRow{Row: rowNumber, Values: values})
+	}
+	return file, nil
+}
+
+// readImportFile accepts a multipart upload (field "file") or a raw text/csv or xlsx body
+func readImportFile(r *http.Request) (string, [][]string, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, MaxImportFileSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "multipart/form-data" {
+		err := r.ParseMultipartForm(MaxImportFileSize)
+		if isBodyTooLarge(err) {
+			return "", nil, err
+		}
+		if err != nil {
+			return "", nil, errors.New("Invalid multipart upload")
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return "", nil, errors.New("Missing upload field 'file'")
+		}
+		defer file.Close()
+
+		switch strings.ToLower(filepath.Ext(header.Filename)) {
+		case ".csv":
+			records, err := readCSVRecords(file)
+			return header.Filename, records, err
+		case ".xlsx":
+			records, err := utils.ReadXLSXRows(file, header.Size)
+			return header.Filename, records, err
+		}
+		return "", nil, errors.New("Unsupported file type, upload a .csv or .xlsx file")
+	}
+
+	switch mediaType {
+	case "text/csv":
+		records, err := readCSVRecords(r.Body)
+		return "upload.csv", records, err
+	case xlsxContentType:
+		body, err := io.ReadAll(r.Body)
+		if isBodyTooLarge(err) {
+			return "", nil, err
+		}
+		if err != nil {
+			return "", nil, errors.New("Invalid Request Body")
+		}
+		records, err := utils.ReadXLSXRows(bytes.NewReader(body), int64(len(body)))
+		return "upload.xlsx", records, err
+	}
+	return "", nil, errors.New("Unsupported Content-Type, use multipart/form-data, text/csv or " + xlsxContentType)
+}
+
+func readCSVRecords(reader io.Reader) ([][]string, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	records, err := csvReader.ReadAll()
+	if isBodyTooLarge(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid csv file: %v", err)
+	}
+	if len(records) > 0 && len(records[0]) > 0 {
+		records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff") // byte order mark written by Excel
+	}
+	return records, nil
+}
+
+// parseColumnMapping reads the optional ?mapping= (or form field) json object of header -> field, eg. {"Given Name":"first_name"}
+func parseColumnMapping(r *http.Request) (map[string]string, error) {
+	rawMapping := r.FormValue("mapping")
+	if rawMapping == "" {
+		return nil, nil
+	}
+
+	var mapping map[string]string
+	err := json.Unmarshal([]byte(rawMapping), &mapping)
+	if err != nil {
+		return nil, errors.New("Invalid mapping, expected a json object of header to field")
+	}
+	return mapping, nil
+}
+
+// mapImportColumns maps each header column to a json field of the model. Columns that don't match any field are ignored.
+func mapImportColumns(header []string, model interface{}, mapping map[string]string) (map[int]string, []string, error) {
+	fields := make(map[string]bool)
+	for _, field := range GetFieldNames(model) {
+		if field != "id" {
+			fields[field] = true
+		}
+	}
+
+	explicit := make(map[string]string)
+	for column, field := range mapping {
+		if !fields[field] {
+			return nil, nil, fmt.Errorf("Invalid mapping, unknown field '%s'", field)
+		}
+		explicit[normalizeHeader(column)] = field
+	}
+
+	columns := make(map[int]string)
+	mapped := make(map[string]bool)
+	ignored := []string{}
+	for i, column := range header {
+		name := normalizeHeader(column)
+		field, ok := explicit[name]
+		if !ok && fields[name] {
+			field, ok = name, true
+		}
+		if !ok || mapped[field] {
+			ignored = append(ignored, column)
+			continue
+		}
+		columns[i] = field
+		mapped[field] = true
+	}
+
+	var missing []string
+	for _, field := range GetFieldNames(model) {
+		if field != "id" && !mapped[field] {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("Missing columns for fields: %s", strings.Join(missing, ", "))
+	}
+	return columns, ignored, nil
+}
+
+// normalizeHeader turns "First Name" / "first-name" into first_name
+func normalizeHeader(header string) string {
+	header = strings.ToLower(strings.TrimSpace(header))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(header)
+}
+
+func validateImportRow(row int, values map[string]string, model interface{}) []models.ImportRowError {
+	var rowErrors []models.ImportRowError
+	for _, field := range GetFieldNames(model) {
+		if field == "id" {
+			continue
+		}
+		if values[field] == "" {
+			rowErrors = append(rowErrors, models.ImportRowError{Row: row, Field: field, Error: "required field is blank"})
+		}
+	}
+	if email := values["email"]; email != "" && !strings.Contains(email, "@") {
+		rowErrors = append(rowErrors, models.ImportRowError{Row: row, Field: "email", Error: "invalid email"})
+	}
+	return rowErrors
+}
+
+func isBlankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// setModelFields sets the string fields of the model (pointer) from values keyed by json field name
+func setModelFields(model interface{}, values map[string]string) {
+	modelVal := reflect.ValueOf(model).Elem()
+	modelType := modelVal.Type()
+
+	for i := 0; i < modelVal.NumField(); i++ {
+		field := strings.TrimSuffix(modelType.Field(i).Tag.Get("json"), ",omitempty")
+		value, ok := values[field]
+		if ok && modelVal.Field(i).Kind() == reflect.String {
+			modelVal.Field(i).SetString(value)
+		}
+	}
+}
+
+func isDryRun(r *http.Request) (bool, error) {
+	dryRunParam := r.URL.Query().Get("dry_run")
+	if dryRunParam == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(dryRunParam)
+	if err != nil {
+		return false, errors.New("Invalid value for dry_run, use true or false")
+	}
+	return dryRun, nil
+}
+
+func writeDryRunReport(w http.ResponseWriter, file importFile) {
+	w.Header().Set("Content-Type", "application/json")
+	response := struct {
+		Status         string                  `json:"status"`
+		DryRun         bool                    `json:"dry_run"`
+		FileName       string                  `json:"file_name"`
+		TotalRows      int                     `json:"total_rows"`
+		ValidRows      int                     `json:"valid_rows"`
+		InvalidRows    int                     `json:"invalid_rows"`
+		IgnoredColumns []string                `json:"ignored_columns"`
+		Errors         []models.ImportRowError `json:"errors"`
+	}{
+		Status:         "success",
+		DryRun:         true,
+		FileName:       file.FileName,
+		TotalRows:      file.TotalRows,
+		ValidRows:      len(file.Rows),
+		InvalidRows:    file.TotalRows - len(file.Rows),
+		IgnoredColumns: file.IgnoredColumns,
+		Errors:         file.Errors,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// startImportJob records the import job, responds 202 with the job and runs the import in the background
+func startImportJob(w http.ResponseWriter, r *http.Request, entity string, file importFile, run func(ctx context.Context, onRow func(index int, id int, created bool, err error)) error) {
+	invalidRows := file.TotalRows - len(file.Rows)
+
+	job, err := sqlconnect.CreateImportJobDbHandler(r.Context(), models.ImportJob{
+		Entity:        entity,
+		FileName:      file.FileName,
+		Status:        models.ImportStatusPending,
+		TotalRows:     file.TotalRows,
+		ProcessedRows: invalidRows,
+		FailedRows:    invalidRows,
+		Errors:        file.Errors,
+	})
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the job is stopped between rows on shutdown and marked failed, it can be rerun as the import upserts by email
+	// the job keeps the request's logger and id, so its lines and queries can be traced back to the upload
+	logger := utils.Logger(r.Context()).With("import_job", job.ID)
+	// the job outlives the upload, it gets a trace of its own linked to the upload's
+	uploadSpan := trace.LinkFromContext(r.Context())
+	lifecycle.Go(func(ctx context.Context) {
+		ctx = utils.ContextWithRequestID(utils.ContextWithLogger(ctx, logger), job.RequestID)
+		ctx, span := tracer.Start(ctx, "import job", trace.WithLinks(uploadSpan), trace.WithAttributes(attribute.Int("import_job.id", job.ID)))
+		defer span.End()
+		runImportJob(ctx, job, file.Rows, run)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	location, err := RouteURL("v1.imports.show", "id", strconv.Itoa(job.ID))
+	if err == nil {
+		w.Header().Set("Location", location)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func runImportJob(ctx context.Context, job models.ImportJob, rows []importRow, run func(ctx context.Context, onRow func(index int, id int, created bool, err error)) error) {
+	job.Status = models.ImportStatusRunning
+	sqlconnect.UpdateImportJobDbHandler(ctx, job)
+
+	err := run(ctx, func(index int, id int, created bool, err error) {
+		job.ProcessedRows++
+		switch {
+		case err != nil:
+			job.FailedRows++
+			job.Errors = append(job.Errors, models.ImportRowError{Row: rows[index].Row, Error: err.Error()})
+		case created:
+			job.CreatedRows++
+		default:
+			job.UpdatedRows++
+		}
+
+		if job.ProcessedRows%importProgressInterval == 0 {
+			sqlconnect.UpdateImportJobDbHandler(ctx, job)
+		}
+	})
+
+	job.Status = models.ImportStatusCompleted
+	if err != nil {
+		job.Status = models.ImportStatusFailed
+		job.Errors = append(job.Errors, models.ImportRowError{Error: err.Error()})
+	}
+	// ctx is cancelled on shutdown, the final state has to be recorded anyway
+	sqlconnect.UpdateImportJobDbHandler(context.WithoutCancel(ctx), job)
+}
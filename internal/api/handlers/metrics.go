@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+	"school-management/pkg/metrics"
+)
+
+var loginFailures = metrics.Default.NewCounterVec("login_failures_total",
+	"Failed exec logins by reason.", "reason")
+
+// login failure reasons, clients only see a generic message for some of them
+const (
+	loginInvalidRequest = "invalid_request"
+	loginUnknownUser    = "unknown_user"
+	loginInactive       = "inactive"
+	loginWrongPassword  = "wrong_password"
+	loginError          = "error"
+)
+
+// GET /metrics
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.Default.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"school-management/pkg/jsonpatch"
+	"school-management/pkg/utils"
+	"slices"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// acceptPatch lists the patch formats of the PATCH routes, it is sent along with 415
+var acceptPatch = mergePatchContentType + ", " + jsonPatchContentType
+
+// the fields a PATCH may change, the others (id, passwords, reset tokens) are read only
+var (
+	studentPatchFields = []string{"first_name", "last_name", "email", "class"}
+	teacherPatchFields = []string{"first_name", "last_name", "email", "class", "subject"}
+	execPatchFields    = []string{"first_name", "last_name", "email", "username", "role", "inactive_status"}
+)
+
+// patchError is a patch which can't be applied to the resource, it is answered with its status
+type patchError struct {
+	status  int
+	message string
+}
+
+func (e *patchError) Error() string {
+	return e.message
+}
+
+func newPatchError(status int, format string, args ...interface{}) *patchError {
+	return &patchError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// patchFunc applies a decoded patch to the json document of a resource
+type patchFunc func(doc interface{}) (interface{}, error)
+
+// decodePatch parses the body of a PATCH request by its content type: application/merge-patch+json (RFC 7396),
+// application/json-patch+json (RFC 6902) or application/json, which is a merge patch as well
+func decodePatch(r *http.Request, body []byte) (patchFunc, error) {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, newPatchError(http.StatusUnsupportedMediaType, "Invalid Content-Type, use %s", acceptPatch)
+		}
+	}
+
+	switch mediaType {
+	case mergePatchContentType, "application/json":
+		var mergePatch interface{}
+		err := json.Unmarshal(body, &mergePatch)
+		if err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "Invalid merge patch: %v", err)
+		}
+		return func(doc interface{}) (interface{}, error) {
+			return jsonpatch.MergePatch(doc, mergePatch), nil
+		}, nil
+	case jsonPatchContentType:
+		patch, err := jsonpatch.Decode(body)
+		if err != nil {
+			return nil, newPatchError(http.StatusBadRequest, "%v", err)
+		}
+		return patch.Apply, nil
+	}
+	return nil, newPatchError(http.StatusUnsupportedMediaType, "Unsupported Content-Type %s, use %s", mediaType, acceptPatch)
+}
+
+// patchModel applies patch to the document of the id and the given fields of model (a pointer) and sets the fields from the result.
+// Removed (or null) fields are cleared, the result has to pass the same checks as a new resource.
+func patchModel(model interface{}, fields []string, patch patchFunc) error {
+	modelVal := reflect.ValueOf(model).Elem()
+	fieldIndex := make(map[string]int)
+	for i := 0; i < modelVal.NumField(); i++ {
+		name, _, _ := strings.Cut(modelVal.Type().Field(i).Tag.Get("json"), ",")
+		fieldIndex[name] = i
+	}
+
+	// the document holds the values as encoding/json decodes them, so test operations compare like with like
+	doc := map[string]interface{}{"id": modelVal.Field(fieldIndex["id"]).Interface()}
+	for _, field := range fields {
+		doc[field] = modelVal.Field(fieldIndex[field]).Interface()
+	}
+	rawDoc, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var original interface{}
+	err = json.Unmarshal(rawDoc, &original)
+	if err != nil {
+		return err
+	}
+
+	patched, err := patch(original)
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed), errors.Is(err, jsonpatch.ErrPathNotFound):
+		return newPatchError(http.StatusConflict, "%v", err)
+	case err != nil:
+		return newPatchError(http.StatusBadRequest, "%v", err)
+	}
+
+	object, ok := patched.(map[string]interface{})
+	if !ok {
+		return newPatchError(http.StatusUnprocessableEntity, "The patched document must be an object")
+	}
+	if !reflect.DeepEqual(object["id"], original.(map[string]interface{})["id"]) {
+		return newPatchError(http.StatusUnprocessableEntity, "id can't be changed")
+	}
+	for key := range object {
+		if key != "id" && !slices.Contains(fields, key) {
+			return newPatchError(http.StatusUnprocessableEntity, "%s can't be patched, patchable fields: %s", key, strings.Join(fields, ", "))
+		}
+	}
+
+	for _, field := range fields {
+		fieldVal := modelVal.Field(fieldIndex[field])
+		value, ok := object[field]
+		if !ok || value == nil {
+			fieldVal.SetZero()
+			continue
+		}
+
+		rawValue, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		newVal := reflect.New(fieldVal.Type())
+		err = json.Unmarshal(rawValue, newVal.Interface())
+		if err != nil {
+			return newPatchError(http.StatusUnprocessableEntity, "%s must be a %s", field, jsonTypeName(fieldVal.Kind()))
+		}
+		fieldVal.Set(newVal.Elem())
+	}
+
+	return validatePatchedFields(modelVal, fieldIndex, fields)
+}
+
+// validatePatchedFields applies the checks of new resources (CheckBlankFields, the email check of the imports) to the patched fields
+func validatePatchedFields(modelVal reflect.Value, fieldIndex map[string]int, fields []string) error {
+	for _, field := range fields {
+		fieldVal := modelVal.Field(fieldIndex[field])
+		if fieldVal.Kind() != reflect.String {
+			continue
+		}
+		value := strings.TrimSpace(fieldVal.String())
+		if value == "" {
+			return newPatchError(http.StatusUnprocessableEntity, "%s is required and can't be cleared", field)
+		}
+		if field == "email" && !strings.Contains(value, "@") {
+			return newPatchError(http.StatusUnprocessableEntity, "invalid email")
+		}
+	}
+	return nil
+}
+
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	}
+	return "string"
+}
+
+// writePatchError answers errors of decodePatch, patchModel and the repository
+func writePatchError(w http.ResponseWriter, r *http.Request, err error) {
+	var patchErr *patchError
+	if errors.As(err, &patchErr) {
+		if patchErr.status == http.StatusUnsupportedMediaType {
+			w.Header().Set("Accept-Patch", acceptPatch)
+		}
+		utils.WriteProblem(w, r, patchErr.status, patchErr.message)
+		return
+	}
+	utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"school-management/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestPatchModel(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int // 0 when the patch applies
+		expected    models.Exec
+	}{
+		{"merge patch", mergePatchContentType, `{"email":"new@school.com","inactive_status":true}`, 0,
+			models.Exec{ID: 7, FirstName: "Ada", LastName: "Lovelace", Email: "new@school.com", Username: "ada", Role: "admin", InactiveStatus: true}},
+		{"passing test op", jsonPatchContentType, `[{"op":"test","path":"/id","value":7},{"op":"test","path":"/inactive_status","value":false},{"op":"replace","path":"/role","value":"manager"}]`, 0,
+			models.Exec{ID: 7, FirstName: "Ada", LastName: "Lovelace", Email: "ada@school.com", Username: "ada", Role: "manager"}},
+		{"failed test op", jsonPatchContentType, `[{"op":"test","path":"/email","value":"old@school.com"},{"op":"replace","path":"/role","value":"manager"}]`, http.StatusConflict, models.Exec{}},
+		{"missing path", jsonPatchContentType, `[{"op":"replace","path":"/nickname","value":"ada"}]`, http.StatusConflict, models.Exec{}},
+		{"read only field", jsonPatchContentType, `[{"op":"add","path":"/password","value":"secret"}]`, http.StatusUnprocessableEntity, models.Exec{}},
+		{"read only field in a merge patch", mergePatchContentType, `{"password_reset_token":"abc"}`, http.StatusUnprocessableEntity, models.Exec{}},
+		{"changed id", mergePatchContentType, `{"id":8}`, http.StatusUnprocessableEntity, models.Exec{}},
+		{"cleared required field", mergePatchContentType, `{"first_name":null}`, http.StatusUnprocessableEntity, models.Exec{}},
+		{"wrong type", mergePatchContentType, `{"inactive_status":"yes"}`, http.StatusUnprocessableEntity, models.Exec{}},
+		{"invalid email", jsonPatchContentType, `[{"op":"replace","path":"/email","value":"ada"}]`, http.StatusUnprocessableEntity, models.Exec{}},
+		{"not an object", jsonPatchContentType, `[{"op":"replace","path":"","value":[]}]`, http.StatusUnprocessableEntity, models.Exec{}},
+		{"invalid patch", jsonPatchContentType, `[{"op":"add","path":"/email"}]`, http.StatusBadRequest, models.Exec{}},
+		{"unsupported content type", "text/plain", `email=a`, http.StatusUnsupportedMediaType, models.Exec{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := models.Exec{ID: 7, FirstName: "Ada", LastName: "Lovelace", Email: "ada@school.com", Username: "ada", Password: "hash", Role: "admin"}
+			r := httptest.NewRequest(http.MethodPatch, "/api/v1/execs/7", nil)
+			r.Header.Set("Content-Type", tt.contentType)
+
+			patch, err := decodePatch(r, []byte(tt.body))
+			if err == nil {
+				err = patchModel(&exec, execPatchFields, patch)
+			}
+
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.expected.Password = "hash"
+				if exec != tt.expected {
+					t.Errorf("expected %+v, got %+v", tt.expected, exec)
+				}
+				return
+			}
+			var patchErr *patchError
+			if !errors.As(err, &patchErr) || patchErr.status != tt.status {
+				t.Fatalf("expected a %d patch error, got %v", tt.status, err)
+			}
+
+			w := httptest.NewRecorder()
+			writePatchError(w, r, err)
+			if w.Code != tt.status || w.Header().Get("Content-Type") != "application/problem+json" {
+				t.Errorf("expected a %d problem, got %d %q", tt.status, w.Code, w.Header().Get("Content-Type"))
+			}
+			if tt.status == http.StatusUnsupportedMediaType && !strings.Contains(w.Header().Get("Accept-Patch"), jsonPatchContentType) {
+				t.Errorf("expected Accept-Patch with the 415, got %q", w.Header().Get("Accept-Patch"))
+			}
+		})
+	}
+}
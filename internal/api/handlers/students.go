@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"school-management/internal/models"
 	"school-management/internal/repository/sqlconnect"
+	"school-management/pkg/utils"
 	"strconv"
 )
 
@@ -27,20 +27,20 @@ import (
 // }
 
 func GetOneStudentHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("getStudentHandler:", r.URL)
+	utils.Logger(r.Context()).Debug("getOneStudentHandler")
 
 	idStr := r.PathValue("id")
 
 	//Handle Path Parameter
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing id: %v", err), http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Error parsing id: %v", err))
 		return
 	}
 
-	Student, err := sqlconnect.GetStudentByIdDbHandler(id)
+	Student, err := sqlconnect.GetStudentByIdDbHandler(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -49,12 +49,26 @@ func GetOneStudentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetStudentsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("getStudentsHandler:", r.URL)
+	utils.Logger(r.Context()).Debug("getStudentsHandler")
+
+	format, err := exportFormat(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if format != formatJSON {
+		exporter := newRowExporter(w, format, "students", studentExportColumns)
+		err = sqlconnect.StreamStudentsDbHandler(r, func(row models.Student) error {
+			return exporter.WriteRow(row)
+		})
+		finishExport(w, r, exporter, err)
+		return
+	}
 
 	var Students []models.Student
-	Students, err := sqlconnect.GetStudentsDbHandler(Students, r)
+	Students, err = sqlconnect.GetStudentsDbHandler(Students, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	response := struct {
@@ -71,12 +85,17 @@ func GetStudentsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func AddStudentsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("postStudentsHandler:", r.URL, r.Body)
+	utils.Logger(r.Context()).Debug("addStudentsHandler")
+
+	atomic, err := isAtomicRequest(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	reqBody, err := io.ReadAll(r.Body) // store req body as it gets wiped out on reading once only
-	fmt.Println(string(reqBody))
 	if err != nil {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid Request Body")
 		return
 	}
 	defer r.Body.Close()
@@ -86,7 +105,18 @@ func AddStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(reqBody, &rawStudents)
 	if err != nil {
-		http.Error(w, "Invalid Request Bodyas", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Bodyas")
+		return
+	}
+
+	// ?atomic=false - insert what we can and report the outcome per item
+	if !atomic {
+		err = json.Unmarshal(reqBody, &newStudents)
+		if err != nil {
+			utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Body")
+			return
+		}
+		addStudentsPartial(w, r, rawStudents, newStudents)
 		return
 	}
 
@@ -101,7 +131,7 @@ func AddStudentsHandler(w http.ResponseWriter, r *http.Request) {
 		for key := range Student {
 			_, ok := allowedFields[key]
 			if !ok {
-				http.Error(w, "Unacceptable field found in request. Only use allowed fields.", http.StatusBadRequest)
+				utils.WriteProblem(w, r, http.StatusBadRequest, "Unacceptable field found in request. Only use allowed fields.")
 				return
 			}
 		}
@@ -109,7 +139,7 @@ func AddStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(reqBody, &newStudents)
 	if err != nil {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Body")
 		return
 	}
 
@@ -121,16 +151,16 @@ func AddStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 		err = CheckBlankFields(Student)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 	}
 
 	var addedStudents []models.Student
 
-	addedStudents, err = sqlconnect.AddStudentsDbHandler(addedStudents, newStudents)
+	addedStudents, err = sqlconnect.AddStudentsDbHandler(r.Context(), addedStudents, newStudents)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -153,12 +183,12 @@ func AddStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 // PUT /Students/{id}  - PUT replaces all fields even if left empty
 func UpdateStudentsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("update Students handler:", r.URL)
+	utils.Logger(r.Context()).Debug("updateStudentsHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid Student id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Student id")
 		return
 	}
 
@@ -166,13 +196,13 @@ func UpdateStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewDecoder(r.Body).Decode(&updatedStudent)
 	if err != nil {
-		http.Error(w, "Invalid Request payload", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid Request payload")
 		return
 	}
 
-	updatedStudentFromDB, err := sqlconnect.UpdateStudentByIdDbHandle(id, updatedStudent)
+	updatedStudentFromDB, err := sqlconnect.UpdateStudentByIdDbHandle(r.Context(), id, updatedStudent)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -180,27 +210,34 @@ func UpdateStudentsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updatedStudentFromDB)
 }
 
-// PATCH /Students/{id} - PATCH only updated the given fields
+// PATCH /Students/{id} - a merge patch (RFC 7396) or a json patch (RFC 6902), see decodePatch
 func PatchOneStudentHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("patch Students handler:", r.URL)
+	utils.Logger(r.Context()).Debug("patchOneStudentHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid Student id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Student id")
 		return
 	}
 
-	var updates map[string]string
-	err = json.NewDecoder(r.Body).Decode(&updates)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid request body")
+		return
+	}
+	patch, err := decodePatch(r, body)
+	if err != nil {
+		writePatchError(w, r, err)
 		return
 	}
 
-	updatedStudent, err := sqlconnect.PatchStudentByIdDbHandler(id, updates)
+	// the patch is applied to the locked row, a failing test op or an invalid result leaves the student as it was
+	updatedStudent, err := sqlconnect.PatchStudentByIdDbHandler(r.Context(), id, func(student *models.Student) error {
+		return patchModel(student, studentPatchFields, patch)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writePatchError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -212,13 +249,13 @@ func PatchStudentsHandler(w http.ResponseWriter, r *http.Request) {
 	var updates []map[string]interface{}
 	err := json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid request payload")
 		return
 	}
 
-	err = sqlconnect.PatchStudentsDbHandler(updates)
+	err = sqlconnect.PatchStudentsDbHandler(r.Context(), updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -227,19 +264,19 @@ func PatchStudentsHandler(w http.ResponseWriter, r *http.Request) {
 
 // DELETE /Students/{id}
 func DeleteStudentHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("delete Student handler:", r.URL)
+	utils.Logger(r.Context()).Debug("deleteStudentHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid Student id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Student id")
 		return
 	}
 
-	err = sqlconnect.DeleteStudentByIdDbHandler(id)
+	err = sqlconnect.DeleteStudentByIdDbHandler(r.Context(), id)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -260,19 +297,19 @@ func DeleteStudentHandler(w http.ResponseWriter, r *http.Request) {
 
 // DELETE /Students - multiple Students
 func DeleteStudentsHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("delete Students handler:", r.URL)
+	utils.Logger(r.Context()).Debug("deleteStudentsHandler")
 
 	var ids []int
 
 	err := json.NewDecoder(r.Body).Decode(&ids)
 	if err != nil {
-		http.Error(w, "Invalid Student ids", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid Student ids")
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteStudentsDbHandler(ids)
+	deletedIds, err := sqlconnect.DeleteStudentsDbHandler(r.Context(), ids)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -288,3 +325,37 @@ func DeleteStudentsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 
 }
+
+// addStudentsPartial validates and inserts every student on its own and responds with 207 Multi-Status
+func addStudentsPartial(w http.ResponseWriter, r *http.Request, rawStudents []map[string]interface{}, newStudents []models.Student) {
+	results := make([]models.BulkItemResult, len(newStudents))
+
+	var validStudents []models.Student
+	var validIndexes []int
+	for i, student := range newStudents {
+		err := checkAllowedFields(rawStudents[i], models.Student{})
+		if err == nil {
+			err = CheckBlankFields(student)
+		}
+		if err != nil {
+			results[i] = models.BulkItemResult{Index: i, Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		validStudents = append(validStudents, student)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(validStudents) > 0 {
+		dbResults, err := sqlconnect.AddStudentsPartialDbHandler(r.Context(), validStudents)
+		if err != nil {
+			utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for j, result := range dbResults {
+			result.Index = validIndexes[j]
+			results[validIndexes[j]] = result
+		}
+	}
+
+	writeBulkResults(w, results)
+}
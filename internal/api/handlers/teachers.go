@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"school-management/internal/models"
 	"school-management/internal/repository/sqlconnect"
+	"school-management/pkg/utils"
 	"strconv"
 )
 
@@ -27,20 +27,20 @@ import (
 // }
 
 func GetOneTeacherHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("getTeacherHandler:", r.URL)
+	utils.Logger(r.Context()).Debug("getOneTeacherHandler")
 
 	idStr := r.PathValue("id")
 
 	//Handle Path Parameter
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing id: %v", err), http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Error parsing id: %v", err))
 		return
 	}
 
-	teacher, err := sqlconnect.GetTeacherByIdDbHandler(id)
+	teacher, err := sqlconnect.GetTeacherByIdDbHandler(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -49,12 +49,26 @@ func GetOneTeacherHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetTeachersHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("getTeachersHandler:", r.URL)
+	utils.Logger(r.Context()).Debug("getTeachersHandler")
+
+	format, err := exportFormat(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if format != formatJSON {
+		exporter := newRowExporter(w, format, "teachers", teacherExportColumns)
+		err = sqlconnect.StreamTeachersDbHandler(r, func(row models.Teacher) error {
+			return exporter.WriteRow(row)
+		})
+		finishExport(w, r, exporter, err)
+		return
+	}
 
 	var teachers []models.Teacher
-	teachers, err := sqlconnect.GetTeachersDbHandler(teachers, r)
+	teachers, err = sqlconnect.GetTeachersDbHandler(teachers, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 	response := struct {
@@ -71,11 +85,17 @@ func GetTeachersHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func AddTeachersHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("postTeachersHandler:", r.URL, r.Body)
+	utils.Logger(r.Context()).Debug("addTeachersHandler")
+
+	atomic, err := isAtomicRequest(r)
+	if err != nil {
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	reqBody, err := io.ReadAll(r.Body) // store req body as it gets wiped out on reading once only
 	if err != nil {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid Request Body")
 		return
 	}
 	defer r.Body.Close()
@@ -85,7 +105,18 @@ func AddTeachersHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(reqBody, &rawTeachers)
 	if err != nil {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Body")
+		return
+	}
+
+	// ?atomic=false - insert what we can and report the outcome per item
+	if !atomic {
+		err = json.Unmarshal(reqBody, &newTeachers)
+		if err != nil {
+			utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Body")
+			return
+		}
+		addTeachersPartial(w, r, rawTeachers, newTeachers)
 		return
 	}
 
@@ -100,7 +131,7 @@ func AddTeachersHandler(w http.ResponseWriter, r *http.Request) {
 		for key := range teacher {
 			_, ok := allowedFields[key]
 			if !ok {
-				http.Error(w, "Unacceptable field found in request. Only use allowed fields.", http.StatusBadRequest)
+				utils.WriteProblem(w, r, http.StatusBadRequest, "Unacceptable field found in request. Only use allowed fields.")
 				return
 			}
 		}
@@ -108,7 +139,7 @@ func AddTeachersHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(reqBody, &newTeachers)
 	if err != nil {
-		http.Error(w, "Invalid Request Body", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid Request Body")
 		return
 	}
 
@@ -120,16 +151,16 @@ func AddTeachersHandler(w http.ResponseWriter, r *http.Request) {
 
 		err = CheckBlankFields(teacher)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 	}
 
 	var addedTeachers []models.Teacher
 
-	addedTeachers, err = sqlconnect.AddTeachersDbHandler(addedTeachers, newTeachers)
+	addedTeachers, err = sqlconnect.AddTeachersDbHandler(r.Context(), addedTeachers, newTeachers)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -152,12 +183,12 @@ func AddTeachersHandler(w http.ResponseWriter, r *http.Request) {
 
 // PUT /teachers/{id}  - PUT replaces all fields even if left empty
 func UpdateTeachersHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("update teachers handler:", r.URL)
+	utils.Logger(r.Context()).Debug("updateTeachersHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid teacher id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher id")
 		return
 	}
 
@@ -165,13 +196,13 @@ func UpdateTeachersHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewDecoder(r.Body).Decode(&updatedTeacher)
 	if err != nil {
-		http.Error(w, "Invalid Request payload", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid Request payload")
 		return
 	}
 
-	updatedTeacherFromDB, err := sqlconnect.UpdateTeacherByIdDbHandle(id, updatedTeacher)
+	updatedTeacherFromDB, err := sqlconnect.UpdateTeacherByIdDbHandle(r.Context(), id, updatedTeacher)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -179,27 +210,34 @@ func UpdateTeachersHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updatedTeacherFromDB)
 }
 
-// PATCH /teachers/{id} - PATCH only updated the given fields
+// PATCH /teachers/{id} - a merge patch (RFC 7396) or a json patch (RFC 6902), see decodePatch
 func PatchOneTeacherHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("patch teachers handler:", r.URL)
+	utils.Logger(r.Context()).Debug("patchOneTeacherHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid teacher id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher id")
 		return
 	}
 
-	var updates map[string]string
-	err = json.NewDecoder(r.Body).Decode(&updates)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid request body")
+		return
+	}
+	patch, err := decodePatch(r, body)
+	if err != nil {
+		writePatchError(w, r, err)
 		return
 	}
 
-	updatedTeacher, err := sqlconnect.PatchTeacherByIdDbHandler(id, updates)
+	// the patch is applied to the locked row, a failing test op or an invalid result leaves the teacher as it was
+	updatedTeacher, err := sqlconnect.PatchTeacherByIdDbHandler(r.Context(), id, func(teacher *models.Teacher) error {
+		return patchModel(teacher, teacherPatchFields, patch)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writePatchError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -211,13 +249,13 @@ func PatchTeachersHandler(w http.ResponseWriter, r *http.Request) {
 	var updates []map[string]interface{}
 	err := json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid request payload")
 		return
 	}
 
-	err = sqlconnect.PatchTeachersDbHandler(updates)
+	err = sqlconnect.PatchTeachersDbHandler(r.Context(), updates)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -226,19 +264,19 @@ func PatchTeachersHandler(w http.ResponseWriter, r *http.Request) {
 
 // DELETE /teachers/{id}
 func DeleteTeacherHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("delete teacher handler:", r.URL)
+	utils.Logger(r.Context()).Debug("deleteTeacherHandler")
 
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid teacher id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher id")
 		return
 	}
 
-	err = sqlconnect.DeleteTeacherByIdDbHandler(id)
+	err = sqlconnect.DeleteTeacherByIdDbHandler(r.Context(), id)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -259,19 +297,19 @@ func DeleteTeacherHandler(w http.ResponseWriter, r *http.Request) {
 
 // DELETE /teachers - multiple teachers
 func DeleteTeachersHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("delete teachers handler:", r.URL)
+	utils.Logger(r.Context()).Debug("deleteTeachersHandler")
 
 	var ids []int
 
 	err := json.NewDecoder(r.Body).Decode(&ids)
 	if err != nil {
-		http.Error(w, "Invalid teacher ids", http.StatusBadRequest)
+		bodyError(w, r, err, "Invalid teacher ids")
 		return
 	}
 
-	deletedIds, err := sqlconnect.DeleteTeachersDbHandler(ids)
+	deletedIds, err := sqlconnect.DeleteTeachersDbHandler(r.Context(), ids)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, errorStatusCode(err), err.Error())
 		return
 	}
 
@@ -293,15 +331,15 @@ func GetStudentsByTeacherId(w http.ResponseWriter, r *http.Request) {
 
 	teacherId, err := strconv.Atoi(teacherIdFromPath)
 	if err != nil {
-		http.Error(w, "Invalid teacher Id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher Id")
 		return
 	}
 
 	var students []models.Student
 
-	students, err = sqlconnect.GetStudentsByTeacherIdDbHandler(teacherId, students)
+	students, err = sqlconnect.GetStudentsByTeacherIdDbHandler(r.Context(), teacherId, students)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -324,13 +362,13 @@ func GetStudentCountById(w http.ResponseWriter, r *http.Request) {
 
 	teacherId, err := strconv.Atoi(teacherIdFromPath)
 	if err != nil {
-		http.Error(w, "Invalid teacher Id", http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid teacher Id")
 		return
 	}
 
-	studentCount, err := sqlconnect.GetStudentCountByTeacherIdDbHandler(teacherId)
+	studentCount, err := sqlconnect.GetStudentCountByTeacherIdDbHandler(r.Context(), teacherId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		utils.WriteProblem(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -345,3 +383,37 @@ func GetStudentCountById(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// addTeachersPartial validates and inserts every teacher on its own and responds with 207 Multi-Status
+func addTeachersPartial(w http.ResponseWriter, r *http.Request, rawTeachers []map[string]interface{}, newTeachers []models.Teacher) {
+	results := make([]models.BulkItemResult, len(newTeachers))
+
+	var validTeachers []models.Teacher
+	var validIndexes []int
+	for i, teacher := range newTeachers {
+		err := checkAllowedFields(rawTeachers[i], models.Teacher{})
+		if err == nil {
+			err = CheckBlankFields(teacher)
+		}
+		if err != nil {
+			results[i] = models.BulkItemResult{Index: i, Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		validTeachers = append(validTeachers, teacher)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(validTeachers) > 0 {
+		dbResults, err := sqlconnect.AddTeachersPartialDbHandler(r.Context(), validTeachers)
+		if err != nil {
+			utils.WriteProblem(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for j, result := range dbResults {
+			result.Index = validIndexes[j]
+			results[validIndexes[j]] = result
+		}
+	}
+
+	writeBulkResults(w, results)
+}
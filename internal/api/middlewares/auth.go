@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"school-management/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuthOptions struct {
+	// JWTSecret verifies the login tokens, sent as "Authorization: Bearer <token>" or in the Bearer cookie
+	JWTSecret string
+	// APIKeys are the keys accepted in the X-API-Key header by client name
+	APIKeys map[string]string
+}
+
+type authentication struct {
+	secret  []byte
+	apiKeys map[[sha256.Size]byte]string // client names by the hash of their key
+}
+
+// NewAuthentication identifies who sends a request from a valid login token or api key. It doesn't refuse anything,
+// requests with missing or invalid credentials stay anonymous (eg. /health/details checks a token of its own).
+// The rate limiter and the request logger only ever see the identities verified here.
+func NewAuthentication(options AuthOptions) func(http.Handler) http.Handler {
+	a := &authentication{secret: []byte(options.JWTSecret), apiKeys: make(map[[sha256.Size]byte]string)}
+	for name, key := range options.APIKeys {
+		a.apiKeys[sha256.Sum256([]byte(key))] = name
+	}
+	return a.middleware
+}
+
+func (a *authentication) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if id, ok := a.execID(r); ok {
+			ctx = ContextWithExecID(ctx, id)
+		}
+		// the keys are compared by hash, the lookup doesn't leak how much of a key was right
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			if name, ok := a.apiKeys[sha256.Sum256([]byte(apiKey))]; ok {
+				ctx = ContextWithAPIClient(ctx, name)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// execID is the exec a valid login token was issued to
+func (a *authentication) execID(r *http.Request) (int, bool) {
+	if len(a.secret) == 0 {
+		return 0, false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		cookie, err := r.Cookie("Bearer")
+		if err != nil {
+			return 0, false
+		}
+		token = cookie.Value
+	}
+
+	claims, err := utils.VerifyToken(token, a.secret, time.Now())
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	return id, err == nil
+}
+
+type execIDKey struct{}
+
+// ContextWithExecID records the authenticated exec of a request, the exec rate limit key uses it
+// and it becomes the principal of the request logger
+func ContextWithExecID(ctx context.Context, id int) context.Context {
+	setPrincipal(ctx, execPrincipal(id))
+	return context.WithValue(ctx, execIDKey{}, id)
+}
+
+type apiClientKey struct{}
+
+// ContextWithAPIClient records the client whose api key was verified, the api_key rate limit key uses it
+func ContextWithAPIClient(ctx context.Context, name string) context.Context {
+	if _, ok := ctx.Value(execIDKey{}).(int); !ok {
+		setPrincipal(ctx, "api_key:"+name)
+	}
+	return context.WithValue(ctx, apiClientKey{}, name)
+}
@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"school-management/pkg/utils"
+)
+
+type BodyLimitOptions struct {
+	// Default is the limit in bytes of routes without their own, 0 disables it
+	Default int64
+	// Routes are ServeMux patterns ("POST /api/v1/students/import") with their limit in bytes
+	Routes map[string]int64
+}
+
+type bodyLimit struct {
+	options BodyLimitOptions
+	routes  *http.ServeMux // only used to match the route patterns of the limits
+}
+
+// NewBodyLimit caps request bodies with http.MaxBytesReader. Bodies announcing a larger Content-Length are refused
+// with 413 right away, others fail with a *http.MaxBytesError once the handler reads past the limit.
+func NewBodyLimit(options BodyLimitOptions) func(http.Handler) http.Handler {
+	b := &bodyLimit{options: options, routes: http.NewServeMux()}
+	for pattern := range options.Routes {
+		b.routes.Handle(pattern, http.NotFoundHandler())
+	}
+	return b.middleware
+}
+
+func (b *bodyLimit) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := b.options.Default
+		if _, pattern := b.routes.Handler(r); pattern != "" {
+			limit = b.options.Routes[pattern]
+		}
+		if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			utils.WriteProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("The request body exceeds the limit of %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
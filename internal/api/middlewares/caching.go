@@ -0,0 +1,206 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type CachingOptions struct {
+	// Routes are ServeMux patterns ("GET /api/v1/students") with their Cache-Control policy ("private, no-cache"),
+	// other routes keep the Cache-Control set before (no-store by security_headers)
+	Routes map[string]string
+	// MaxBodySize in bytes is held back to compute the ETag, larger or flushed responses (eg. exports) are streamed without it
+	MaxBodySize int
+}
+
+type caching struct {
+	options CachingOptions
+	routes  *http.ServeMux // only used to match the route patterns of the policies
+}
+
+// NewCaching makes the GET routes with a Cache-Control policy revalidatable: 200 responses get the policy and a
+// strong ETag from the hash of their body (unless the handler set one), and requests whose If-None-Match
+// (or If-Modified-Since, when the handler set Last-Modified) still matches are answered with 304 and no body.
+//
+// The entity routes (students, teachers, execs) have no version column, so their only validator is the body hash:
+// they get no Last-Modified and still run their query on every revalidation, a 304 only saves sending the body.
+// The OpenAPI document is the one handler setting Last-Modified (its build time).
+// Placed before compression (the default order) the hash is taken over the encoded bytes, so each content coding
+// gets a strong tag of its own and a 304 always refers to the representation the client holds.
+func NewCaching(options CachingOptions) func(http.Handler) http.Handler {
+	c := &caching{options: options, routes: http.NewServeMux()}
+	for pattern := range options.Routes {
+		c.routes.Handle(pattern, http.NotFoundHandler())
+	}
+	return c.middleware
+}
+
+func (c *caching) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, pattern := c.routes.Handler(r)
+		if pattern == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &cachingWriter{ResponseWriter: w, maxSize: c.options.MaxBodySize}
+		next.ServeHTTP(cw, r)
+		cw.finish(r, c.options.Routes[pattern])
+	})
+}
+
+// cachingWriter holds the response back until the handler returned, so the ETag can be computed from the whole body
+type cachingWriter struct {
+	http.ResponseWriter
+	maxSize int
+
+	status    int
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func (cw *cachingWriter) WriteHeader(code int) {
+	// informational responses don't end the header, they are passed on directly
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *cachingWriter) Write(b []byte) (int, error) {
+	if cw.streaming {
+		return cw.ResponseWriter.Write(b)
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.buf.Write(b)
+	if cw.maxSize > 0 && cw.buf.Len() > cw.maxSize {
+		err := cw.stream()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// stream gives up on the validators and sends the held back response, later writes pass through
+func (cw *cachingWriter) stream() error {
+	cw.streaming = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	_, err := cw.ResponseWriter.Write(cw.buf.Bytes())
+	cw.buf = bytes.Buffer{}
+	return err
+}
+
+// Flush streams the response, a handler flushing wants its client to see the body before it is complete
+func (cw *cachingWriter) Flush() {
+	if !cw.streaming && cw.stream() != nil {
+		return
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *cachingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish sends the held back response with policy and validators, or 304 if the client's copy is still current.
+// Only 200 responses are cacheable, the others are sent as the handler wrote them.
+func (cw *cachingWriter) finish(r *http.Request, policy string) {
+	if cw.streaming {
+		return
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if cw.status == http.StatusOK {
+		header.Set("Cache-Control", policy)
+		if header.Get("ETag") == "" {
+			header.Set("ETag", strongETag(cw.buf.Bytes()))
+		}
+
+		if notModified(r, header) {
+			// like http.ServeContent, a 304 carries the validators but no representation metadata
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			header.Del("Content-Encoding")
+			if header.Get("ETag") != "" {
+				header.Del("Last-Modified")
+			}
+			cw.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.ResponseWriter.Write(cw.buf.Bytes())
+}
+
+// strongETag is the truncated sha256 of the body, identical bodies always get the same tag
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified evaluates If-None-Match, If-Modified-Since is only looked at without it (RFC 9110 13.2.2)
+func notModified(r *http.Request, header http.Header) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagListMatches(ifNoneMatch, header.Get("ETag"))
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	// http dates have a resolution of one second
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
+
+// etagListMatches reports whether a list of entity tags ("*" or `"a", W/"b"`) contains etag,
+// If-None-Match compares weakly so W/ prefixes are ignored
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+		if list[0] == '*' {
+			return true
+		}
+
+		candidate := strings.TrimPrefix(list, "W/")
+		if len(candidate) < 2 || candidate[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(candidate[1:], '"')
+		if end < 0 {
+			return false
+		}
+		if candidate[:end+2] == etag {
+			return true
+		}
+		list = candidate[end+2:]
+	}
+}
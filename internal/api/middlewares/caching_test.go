@@ -0,0 +1,200 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCachePolicy = "private, no-cache"
+
+var testLastModified = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newTestCaching(maxBodySize int, handler http.HandlerFunc) http.Handler {
+	return NewCaching(CachingOptions{
+		Routes:      map[string]string{"GET /students": testCachePolicy, "GET /openapi.json": testCachePolicy},
+		MaxBodySize: maxBodySize,
+	})(handler)
+}
+
+func cachedRequest(h http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func studentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"success","data":[`))
+	w.Write([]byte(`{"id":1,"first_name":"Ada"}]}`))
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", testLastModified.Format(http.TimeFormat))
+	w.Write([]byte(`{"openapi":"3.1.0"}`))
+}
+
+func TestCachingIfNoneMatch(t *testing.T) {
+	h := newTestCaching(1<<20, studentsHandler)
+
+	first := cachedRequest(h, "/students", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected 200 with a strong etag, got %d %q", first.Code, etag)
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != testCachePolicy {
+		t.Errorf("expected Cache-Control %q, got %q", testCachePolicy, cc)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		expected    int
+	}{
+		{"same tag", etag, http.StatusNotModified},
+		{"weak comparison", "W/" + etag, http.StatusNotModified},
+		{"in a list", `"other", ` + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"other tag", `"other"`, http.StatusOK},
+		{"malformed", "other", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := cachedRequest(h, "/students", map[string]string{"If-None-Match": tt.ifNoneMatch})
+			if w.Code != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, w.Code)
+			}
+			if tt.expected == http.StatusNotModified {
+				if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+					t.Errorf("expected a 304 without body and Content-Type, got %q %q", w.Header().Get("Content-Type"), w.Body.String())
+				}
+				if w.Header().Get("ETag") != etag {
+					t.Errorf("expected the 304 to carry the etag %q, got %q", etag, w.Header().Get("ETag"))
+				}
+			} else if w.Body.String() != first.Body.String() {
+				t.Errorf("expected the full body, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCachingIfModifiedSince(t *testing.T) {
+	h := newTestCaching(1<<20, openAPIHandler)
+
+	tests := []struct {
+		name     string
+		header   map[string]string
+		expected int
+	}{
+		{"same date", map[string]string{"If-Modified-Since": testLastModified.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"later date", map[string]string{"If-Modified-Since": testLastModified.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+		{"earlier date", map[string]string{"If-Modified-Since": testLastModified.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK},
+		{"invalid date", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+		// If-None-Match wins over If-Modified-Since
+		{"stale etag", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": testLastModified.Format(http.TimeFormat)}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := cachedRequest(h, "/openapi.json", tt.header)
+			if w.Code != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+
+	// entity routes have no Last-Modified, a date alone never revalidates them
+	w := cachedRequest(newTestCaching(1<<20, studentsHandler), "/students", map[string]string{"If-Modified-Since": time.Now().UTC().Format(http.TimeFormat)})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a route without Last-Modified, got %d", w.Code)
+	}
+}
+
+func TestCachingStreamsLargeAndFlushedResponses(t *testing.T) {
+	flushed := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("id,first_name\n"))
+		http.NewResponseController(w).Flush()
+		w.Write([]byte("1,Ada\n"))
+	}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"above MaxBodySize", studentsHandler, `{"status":"success","data":[{"id":1,"first_name":"Ada"}]}`},
+		{"flushed", flushed, "id,first_name\n1,Ada\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestCaching(32, tt.handler)
+			w := cachedRequest(h, "/students", map[string]string{"If-None-Match": "*"})
+			if w.Code != http.StatusOK || w.Body.String() != tt.body {
+				t.Fatalf("expected the whole body with 200, got %d %q", w.Code, w.Body.String())
+			}
+			if etag := w.Header().Get("ETag"); etag != "" {
+				t.Errorf("expected no etag on a streamed response, got %q", etag)
+			}
+		})
+	}
+}
+
+func TestCachingSkipsOtherResponses(t *testing.T) {
+	notFound := func(w http.ResponseWriter, r *http.Request) { http.Error(w, "not found", http.StatusNotFound) }
+	w := cachedRequest(newTestCaching(1<<20, notFound), "/students", map[string]string{"If-None-Match": "*"})
+	if w.Code != http.StatusNotFound || w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected a plain 404, got %d with etag %q and Cache-Control %q", w.Code, w.Header().Get("ETag"), w.Header().Get("Cache-Control"))
+	}
+
+	w = cachedRequest(newTestCaching(1<<20, studentsHandler), "/teachers", nil)
+	if w.Header().Get("ETag") != "" {
+		t.Errorf("expected no etag on a route without policy, got %q", w.Header().Get("ETag"))
+	}
+}
+
+func TestCachingWithCompression(t *testing.T) {
+	body := `{"status":"success","data":[` + strings.Repeat(`{"id":1,"first_name":"Ada"},`, 100) + `{"id":2}]}`
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+	// the default pipeline order, caching sees the compressed response
+	h := newTestCaching(1<<20, NewCompression(DefaultCompressionOptions())(http.HandlerFunc(handler)).ServeHTTP)
+
+	plain := cachedRequest(h, "/students", nil)
+	compressed := cachedRequest(h, "/students", map[string]string{"Accept-Encoding": "gzip"})
+	if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip response, got %q", compressed.Header().Get("Content-Encoding"))
+	}
+	plainETag, compressedETag := plain.Header().Get("ETag"), compressed.Header().Get("ETag")
+	if plainETag == compressedETag || strings.HasPrefix(compressedETag, "W/") {
+		t.Fatalf("expected distinct strong etags per encoding, got %q and %q", plainETag, compressedETag)
+	}
+	if again := cachedRequest(h, "/students", map[string]string{"Accept-Encoding": "gzip"}); again.Header().Get("ETag") != compressedETag {
+		t.Errorf("expected the same etag for the same encoding, got %q and %q", compressedETag, again.Header().Get("ETag"))
+	}
+
+	tests := []struct {
+		acceptEncoding, ifNoneMatch string
+		expected                    int
+	}{
+		{"gzip", compressedETag, http.StatusNotModified},
+		{"", plainETag, http.StatusNotModified},
+		{"", compressedETag, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := cachedRequest(h, "/students", map[string]string{"Accept-Encoding": tt.acceptEncoding, "If-None-Match": tt.ifNoneMatch})
+		if w.Code != tt.expected {
+			t.Errorf("Accept-Encoding %q, If-None-Match %s: expected %d, got %d", tt.acceptEncoding, tt.ifNoneMatch, tt.expected, w.Code)
+			continue
+		}
+		if tt.expected == http.StatusNotModified && (w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0) {
+			t.Errorf("Accept-Encoding %q: expected a 304 without body and Content-Encoding, got %q %d bytes", tt.acceptEncoding, w.Header().Get("Content-Encoding"), w.Body.Len())
+		}
+	}
+}
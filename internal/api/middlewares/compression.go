@@ -1,44 +1,259 @@
 package middlewares
 
 import (
+	"bufio"
 	"compress/gzip"
-	"fmt"
-	"log"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
 )
 
+type CompressionOptions struct {
+	// MinSize is the smallest body worth compressing, smaller responses are sent as they are
+	MinSize int
+	// ContentTypes are the compressible media types, "text/*" allows every text type
+	ContentTypes []string
+}
+
+func DefaultCompressionOptions() CompressionOptions {
+	return CompressionOptions{
+		MinSize:      1024,
+		ContentTypes: []string{"application/json", "application/problem+json", "application/yaml", "application/javascript", "image/svg+xml", "text/*"},
+	}
+}
+
+// encoder is implemented by the gzip, zlib and brotli writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders are in order of preference, it breaks ties between equal q-values.
+// "deflate" is the zlib format (RFC 9110), not raw deflate.
+var encoders = []struct {
+	name string
+	pool *sync.Pool
+}{
+	{name: "br", pool: &sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression) }}},
+	{name: "gzip", pool: &sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}},
+	{name: "deflate", pool: &sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}},
+}
+
 func Compression(next http.Handler) http.Handler {
-	log.Println("Compression Middleware...")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println(("Compression Middleware being returned"))
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
-			return
+	return NewCompression(DefaultCompressionOptions())(next)
+}
+
+// NewCompression is Compression with configurable options
+func NewCompression(options CompressionOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the body depends on Accept-Encoding even if this response ends up uncompressed
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding, pool := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if pool == nil || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, options: options, encoding: encoding, pool: pool}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks the supported encoding with the highest q-value of an Accept-Encoding header
+func negotiateEncoding(acceptEncoding string) (string, *sync.Pool) {
+	qValues := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err == nil && parsed >= 0 && parsed <= 1 {
+					q = parsed
+				}
+			}
 		}
+		qValues[name] = q
+	}
 
-		// Set the response header
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
+	var best string
+	var bestPool *sync.Pool
+	bestQ := 0.0
+	for _, e := range encoders {
+		q, ok := qValues[e.name]
+		if !ok {
+			// the wildcard covers the encodings the client didn't list
+			q = qValues["*"]
+		}
+		if q > bestQ {
+			best, bestPool, bestQ = e.name, e.pool, q
+		}
+	}
+	return best, bestPool
+}
 
-		// Wrap the ResponseWriter with gzipWriter
-		wrappedWriter := &gzipResponseWriter{
-			ResponseWriter: w,
-			Writer:         gz,
+// compressWriter holds back the first MinSize bytes to decide whether the response is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	options  CompressionOptions
+	encoding string
+	pool     *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder encoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// informational responses don't end the header, they are passed on directly
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.options.MinSize {
+			return len(b), nil
 		}
+		err := cw.decide()
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide starts the compressed or plain response and writes the held back bytes
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if len(cw.buf) >= cw.options.MinSize && cw.compressible() {
+		header.Set("Content-Encoding", cw.encoding)
+		// the length of the compressed body isn't known up front
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// a strong etag of the identity body doesn't hold for its compressed bytes
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.encoder = cw.pool.Get().(encoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
 
-		next.ServeHTTP(wrappedWriter, r)
-		fmt.Println(("Sent response from compression middleware"))
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	switch {
+	case cw.status < 200, cw.status == http.StatusNoContent, cw.status == http.StatusNotModified, cw.status == http.StatusPartialContent:
+		return false
+	case header.Get("Content-Encoding") != "", header.Get("Content-Range") != "":
+		// already compressed by the handler, or a byte range of the identity body
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(cw.options.ContentTypes, func(allowed string) bool {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			return strings.HasPrefix(mediaType, prefix+"/")
+		}
+		return strings.EqualFold(mediaType, allowed)
 	})
 }
 
-// gzipResponseWriter wraps http.ResponseWriter to write gzipeed responses
-type gzipResponseWriter struct {
-	http.ResponseWriter
-	Writer *gzip.Writer
+// Flush sends what was written so far, a response flushed before MinSize is reached is not compressed
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.decide() != nil {
+			return
+		}
+	}
+	if cw.encoder != nil {
+		if cw.encoder.Flush() != nil {
+			return
+		}
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("compression: response writer doesn't support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
 }
 
-func (g *gzipResponseWriter) Write(b []byte) (int, error) {
-	return g.Writer.Write(b)
+// close ends the response, bodies that never reached MinSize are sent uncompressed
+func (cw *compressWriter) close() {
+	if !cw.decided && (cw.status != 0 || len(cw.buf) > 0) {
+		cw.decide()
+	}
+	if cw.encoder == nil {
+		return
+	}
+	cw.encoder.Close()
+	cw.encoder.Reset(io.Discard)
+	cw.pool.Put(cw.encoder)
+	cw.encoder = nil
 }
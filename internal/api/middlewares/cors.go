@@ -1,9 +1,12 @@
 package middlewares
 
 import (
-	"log"
 	"net/http"
+	"school-management/pkg/utils"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // api is hosted at www.myapi.com
@@ -16,43 +19,146 @@ var allowedOrigins = []string{
 	"https://localhost:3000",
 }
 
+type CorsOptions struct {
+	// AllowedOrigins are exact origins, "*" for any origin or subdomain patterns like "https://*.example.com"
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// DefaultCorsOptions allows the known frontends with credentials
+func DefaultCorsOptions() CorsOptions {
+	return CorsOptions{
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"Authorization"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+}
+
 func Cors(next http.Handler) http.Handler {
+	return NewCors(DefaultCorsOptions())(next)
+}
+
+// NewCors is Cors with configurable options
+func NewCors(options CorsOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return cors(next, options)
+	}
+}
+
+func cors(next http.Handler, options CorsOptions) http.Handler {
+	allowedMethods := strings.Join(options.AllowedMethods, ", ")
+	exposedHeaders := strings.Join(options.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(options.MaxAge.Seconds()))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on the Origin, caches must not hand it to other origins
+		w.Header().Add("Vary", "Origin")
+
 		origin := r.Header.Get("Origin")
-		log.Printf("CORS middleware - Path: %s, Method: %s, Origin: %s\n", r.URL.Path, r.Method, origin)
+		// not a cors request (curl, server to server calls, same origin navigation)
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		if isOriginAllowed(origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			http.Error(w, "Cors Error", http.StatusForbidden)
+		if !isOriginAllowed(origin, options.AllowedOrigins) {
+			utils.Logger(r.Context()).Warn("cors origin rejected", "origin", origin)
+			utils.WriteProblem(w, r, http.StatusForbidden, "Cors Error")
 			return
 		}
 
-		w.Header().Set("Access-Control-Allow-Headers", "Control-Type, Authorization")
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "3600")
+		if slices.Contains(options.AllowedOrigins, "*") && !options.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if options.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// preflight requests are answered here and never reach the handlers
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			if !slices.Contains(options.AllowedMethods, requestedMethod) {
+				utils.WriteProblem(w, r, http.StatusForbidden, "Cors Error: method not allowed")
+				return
+			}
+			requestedHeaders, ok := allowedRequestHeaders(r.Header.Get("Access-Control-Request-Headers"), options.AllowedHeaders)
+			if !ok {
+				utils.WriteProblem(w, r, http.StatusForbidden, "Cors Error: header not allowed")
+				return
+			}
 
-		// to avoid preflight calls from triggering ahead
-		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			if requestedHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+			}
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		if exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func isOriginAllowed(origin string) bool {
-	// for _, allowedOrigin := range allowedOrigins {
-	// 	if origin == allowedOrigin {
-	// 		return true
-	// 	}
-	// }
+// allowedRequestHeaders checks the headers of a preflight request, they are echoed back when all of them are allowed
+func allowedRequestHeaders(requested string, allowed []string) (string, bool) {
+	if requested == "" {
+		return "", true
+	}
+	if slices.Contains(allowed, "*") {
+		return requested, true
+	}
+
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if !slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(a, header) }) {
+			return "", false
+		}
+	}
+	return requested, true
+}
 
-	if slices.Contains(allowedOrigins, origin) {
-		return true
-	} else {
+func isOriginAllowed(origin string, allowedOrigins []string) bool {
+	for _, allowedOrigin := range allowedOrigins {
+		if allowedOrigin == "*" || strings.EqualFold(origin, allowedOrigin) || matchesOriginPattern(origin, allowedOrigin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesOriginPattern matches subdomain patterns, "https://*.example.com" allows https://app.example.com
+// and https://a.b.example.com but neither https://example.com nor http://app.example.com
+func matchesOriginPattern(origin, pattern string) bool {
+	prefix, suffix, ok := strings.Cut(strings.ToLower(pattern), "*")
+	if !ok {
 		return false
 	}
+	origin = strings.ToLower(origin)
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+	for _, ch := range subdomain {
+		if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') && ch != '-' && ch != '.' {
+			return false
+		}
+	}
+	return true
 }
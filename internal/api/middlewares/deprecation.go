@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DeprecationOptions describe a deprecated route, Sunset and Successor are optional
+type DeprecationOptions struct {
+	DeprecatedAt time.Time
+	Sunset       time.Time
+	// Successor is the path prefix of the replacing api version, eg. /api/v1
+	Successor string
+}
+
+// Deprecation sets the Deprecation (RFC 9745), Sunset (RFC 8594) and successor Link headers on every response
+func Deprecation(options DeprecationOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", options.DeprecatedAt.Unix()))
+			if !options.Sunset.IsZero() {
+				w.Header().Set("Sunset", options.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if options.Successor != "" {
+				successor := strings.TrimSuffix(options.Successor, "/") + r.URL.Path
+				w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,56 +1,128 @@
 package middlewares
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"school-management/pkg/utils"
+	"slices"
 	"strings"
 )
 
+const (
+	DuplicatesFirst  = "first"
+	DuplicatesLast   = "last"
+	DuplicatesReject = "reject"
+)
+
 type HPPOptions struct {
 	CheckQuery                  bool
 	CheckBody                   bool
 	CheckBodyOnlyForContentType string
-	Whitelist                   []string
+	// CheckJSON looks for duplicate object keys in json bodies
+	CheckJSON bool
+	// Whitelist are the params kept on routes without their own whitelist
+	Whitelist []string
+	// Routes are ServeMux patterns ("GET /api/v1/students") with the params the route accepts
+	Routes map[string][]string
+	// Repeatable params may be sent more than once (eg. sortby)
+	Repeatable []string
+	// Duplicates decides which value of a repeated param or json key is kept: first, last or reject (400)
+	Duplicates string
+	// Strict answers 400 instead of silently dropping params that aren't whitelisted or are repeated
+	Strict bool
 }
 
-func Hpp(options HPPOptions) func(http.Handler) http.Handler {
-	fmt.Println("HPP Middleware ...")
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if options.CheckBody && r.Method == http.MethodPost && isCorrectContentType(r, options.CheckBodyOnlyForContentType) {
-				filterBodyParams(r, options.Whitelist)
-			}
-			if options.CheckQuery && r.URL.Query() != nil {
-				filterQueryParams(r, options.Whitelist)
-			}
-			next.ServeHTTP(w, r)
-			fmt.Println("HPP Middleware ends...")
-		})
+type hpp struct {
+	options HPPOptions
+	routes  *http.ServeMux // only used to match the route patterns of the whitelists
+}
+
+func NewHpp(options HPPOptions) (func(http.Handler) http.Handler, error) {
+	switch options.Duplicates {
+	case "":
+		options.Duplicates = DuplicatesFirst
+	case DuplicatesFirst, DuplicatesLast, DuplicatesReject:
+	default:
+		return nil, fmt.Errorf("unknown duplicates policy %q, use first, last or reject", options.Duplicates)
+	}
+
+	h := &hpp{options: options, routes: http.NewServeMux()}
+	for pattern := range options.Routes {
+		h.routes.Handle(pattern, http.NotFoundHandler())
 	}
+	return h.middleware, nil
 }
 
-func isCorrectContentType(r *http.Request, contentType string) bool {
-	return strings.Contains(r.Header.Get("Content-Type"), contentType)
+func (h *hpp) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		whitelist := h.options.Whitelist
+		if _, pattern := h.routes.Handler(r); pattern != "" {
+			whitelist = h.options.Routes[pattern]
+		}
+
+		if h.options.CheckQuery && r.URL.RawQuery != "" {
+			query := r.URL.Query()
+			if !h.check(w, r, h.filterParams(query, whitelist)) {
+				return
+			}
+			r.URL.RawQuery = query.Encode()
+		}
+		if h.options.CheckBody && r.Method == http.MethodPost && isCorrectContentType(r, h.options.CheckBodyOnlyForContentType) {
+			err := r.ParseForm()
+			if err != nil {
+				utils.Logger(r.Context()).Warn("error parsing form data", "error", err)
+			} else if !h.check(w, r, h.filterParams(r.Form, whitelist)) {
+				return
+			}
+		}
+		if h.options.CheckJSON && r.Body != nil && isJSONContentType(r) {
+			if !h.check(w, r, h.filterJSONBody(r)) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-func filterBodyParams(r *http.Request, whitelist []string) {
-	err := r.ParseForm()
-	if err != nil {
-		log.Println("Error parsing form data", err)
-		return
-	}
+// paramProblems are the params the middleware had to drop or deduplicate
+type paramProblems struct {
+	notAllowed []string
+	repeated   []string
+}
 
-	for k, v := range r.Form {
-		if len(v) > 1 {
-			r.Form.Set(k, v[0])
-			// r.Form.Set(k, v[len(v) - 1])  for setting last value
+// check answers 400 if the problems can't be fixed silently, it reports whether the request may go on
+func (h *hpp) check(w http.ResponseWriter, r *http.Request, problems paramProblems) bool {
+	var msgs []string
+	if h.options.Strict {
+		for _, param := range problems.notAllowed {
+			msgs = append(msgs, fmt.Sprintf("parameter %q is not allowed", param))
 		}
-		if !isWhiteListed(k, whitelist) {
-			delete(r.Form, k)
+	}
+	if h.options.Strict || h.options.Duplicates == DuplicatesReject {
+		for _, param := range problems.repeated {
+			msgs = append(msgs, fmt.Sprintf("parameter %q is repeated", param))
 		}
 	}
+	if len(msgs) == 0 {
+		return true
+	}
+	utils.WriteProblem(w, r, http.StatusBadRequest, "Invalid request: "+strings.Join(msgs, ", "))
+	return false
+}
+
+func isCorrectContentType(r *http.Request, contentType string) bool {
+	return strings.Contains(r.Header.Get("Content-Type"), contentType)
+}
 
+func isJSONContentType(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
 }
 
 func isWhiteListed(param string, whitelist []string) bool {
@@ -62,16 +134,141 @@ func isWhiteListed(param string, whitelist []string) bool {
 	return false
 }
 
-func filterQueryParams(r *http.Request, whielist []string) {
-	query := r.URL.Query()
+// filterParams drops params that aren't whitelisted and keeps one value of repeated ones
+func (h *hpp) filterParams(params url.Values, whitelist []string) paramProblems {
+	var problems paramProblems
+	for k, v := range params {
+		if !isWhiteListed(k, whitelist) {
+			problems.notAllowed = append(problems.notAllowed, k)
+			delete(params, k)
+			continue
+		}
+		if len(v) > 1 && !isWhiteListed(k, h.options.Repeatable) {
+			problems.repeated = append(problems.repeated, k)
+			if h.options.Duplicates == DuplicatesLast {
+				params.Set(k, v[len(v)-1])
+			} else {
+				params.Set(k, v[0])
+			}
+		}
+	}
+	slices.Sort(problems.notAllowed)
+	slices.Sort(problems.repeated)
+	return problems
+}
+
+// filterJSONBody keeps one value of every duplicate object key, the body is only rewritten if it had duplicates.
+// Invalid json is passed on as it is, reporting it is up to the handler.
+func (h *hpp) filterJSONBody(r *http.Request) paramProblems {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		// the handler gets the error after the bytes read so far, eg. to answer 413 for a body over the limit
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		utils.Logger(r.Context()).Warn("error reading json body", "error", err)
+		return paramProblems{}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var repeated []string
+	deduplicated, err := dedupeJSONValue(dec, h.options.Duplicates == DuplicatesLast, &repeated)
+	if err != nil || len(repeated) == 0 {
+		return paramProblems{}
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(deduplicated))
+	r.ContentLength = int64(len(deduplicated))
+	slices.Sort(repeated)
+	return paramProblems{repeated: slices.Compact(repeated)}
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
 
-	for k, v := range query {
-		if len(v) > 1 {
-			query.Set(k, v[0]) //first value
+// dedupeJSONValue re-encodes the next json value of dec with one value per object key, keys keep the position of
+// their first occurrence. The duplicate keys are appended to repeated.
+func dedupeJSONValue(dec *json.Decoder, keepLast bool, repeated *[]string) ([]byte, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		var keys []string
+		values := make(map[string][]byte)
+		for dec.More() {
+			keyToken, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyToken.(string)
+			if !ok {
+				return nil, errors.New("object key is not a string")
+			}
+			value, err := dedupeJSONValue(dec, keepLast, repeated)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := values[key]; ok {
+				*repeated = append(*repeated, key)
+				if keepLast {
+					values[key] = value
+				}
+				continue
+			}
+			keys = append(keys, key)
+			values[key] = value
 		}
-		if !isWhiteListed(k, whielist) {
-			query.Del(k)
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
 		}
+
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodedKey, _ := json.Marshal(key)
+			buf.Write(encodedKey)
+			buf.WriteByte(':')
+			buf.Write(values[key])
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+
+	case json.Delim('['):
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			value, err := dedupeJSONValue(dec, keepLast, repeated)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(value)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+
+	default:
+		// strings, json.Number, bools and null
+		return json.Marshal(token)
 	}
-	r.URL.RawQuery = query.Encode()
 }
@@ -0,0 +1,248 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"school-management/internal/lifecycle"
+	"school-management/internal/models"
+	"school-management/pkg/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader       = "Idempotency-Key"
+	idempotentReplayedHeader   = "Idempotent-Replayed"
+	maxIdempotencyKeyLength    = 255
+	idempotencyCleanupInterval = time.Minute
+)
+
+type IdempotencyOptions struct {
+	// Routes are the ServeMux patterns ("POST /api/v1/students") honoring the Idempotency-Key header
+	Routes []string
+	// TTL keeps a response for retries that long
+	TTL time.Duration
+	// LockTimeout frees the key of a request which never completed (eg. its replica crashed) after that long
+	LockTimeout time.Duration
+	// Store is memory (per process) or sql (shared by all replicas through the database)
+	Store string
+}
+
+type idempotency struct {
+	options IdempotencyOptions
+	routes  routeMatcher
+	store   IdempotencyStore
+}
+
+// NewIdempotency makes retries of POST and PATCH requests safe. The first request sent with an Idempotency-Key
+// is served and its response stored, retries with the same key get the stored response replayed. The key is
+// refused with 409 while its request is in flight or if it is reused for a different request.
+// Responses with 5xx aren't stored, the request can be retried with the same key.
+// It has to run inside compression: the stored body is the uncompressed one, encoded again for each retry's Accept-Encoding.
+func NewIdempotency(options IdempotencyOptions) (func(http.Handler) http.Handler, error) {
+	i := &idempotency{options: options, routes: newRouteMatcher(options.Routes)}
+
+	switch options.Store {
+	case IdempotencyStoreMemory, "":
+		i.store = NewMemoryIdempotencyStore()
+	case IdempotencyStoreSQL:
+		i.store = NewSQLIdempotencyStore()
+	default:
+		return nil, fmt.Errorf("unknown idempotency store %q", options.Store)
+	}
+	if options.TTL <= 0 || options.LockTimeout <= 0 {
+		return nil, errors.New("ttl and lock timeout must be positive")
+	}
+
+	// drop expired records, it stops on shutdown
+	lifecycle.Go(i.deleteExpired)
+	return i.middleware, nil
+}
+
+func (i *idempotency) deleteExpired(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := i.store.DeleteExpired(ctx, now)
+			if err != nil {
+				slog.Error("error deleting expired idempotency keys", "error", err)
+			}
+		}
+	}
+}
+
+func (i *idempotency) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) || i.routes.route(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the key may be sent as a structured field string ("...") or as it is
+		if unquoted, err := strconv.Unquote(key); err == nil && strings.HasPrefix(key, `"`) {
+			key = unquoted
+		}
+		if !validIdempotencyKey(key) {
+			utils.WriteProblem(w, r, http.StatusBadRequest, fmt.Sprintf("The %s header must be 1 to %d printable ascii characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				utils.WriteProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("The request body exceeds the limit of %d bytes", maxBytesErr.Limit))
+				return
+			}
+			utils.WriteProblem(w, r, http.StatusBadRequest, "Error reading the request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		claim := models.IdempotencyRecord{
+			Key:         scopedIdempotencyKey(r, key),
+			Fingerprint: requestFingerprint(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(i.options.LockTimeout),
+		}
+		existing, err := i.store.Claim(r.Context(), claim)
+		if err != nil {
+			// like the rate limiter, an unavailable store must not take the api down with it
+			utils.Logger(r.Context()).Error("error claiming idempotency key, serving the request without it", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch {
+		case existing == nil:
+			i.serve(w, r, next, claim)
+		case existing.Fingerprint != claim.Fingerprint:
+			utils.WriteProblem(w, r, http.StatusConflict, fmt.Sprintf("The %s was already used for a different request", idempotencyKeyHeader))
+		case !existing.Completed:
+			w.Header().Set("Retry-After", "1")
+			utils.WriteProblem(w, r, http.StatusConflict, fmt.Sprintf("A request with this %s is still being processed", idempotencyKeyHeader))
+		default:
+			replay(w, *existing)
+		}
+	})
+}
+
+// serve runs the request holding the key and stores its response, the key is freed again if the handler fails or panics
+func (i *idempotency) serve(w http.ResponseWriter, r *http.Request, next http.Handler, claim models.IdempotencyRecord) {
+	// the outcome has to be recorded even if the client went away
+	ctx := context.WithoutCancel(r.Context())
+	// headers set by the middlewares before this one belong to this response only
+	before := w.Header().Clone()
+
+	rec := &responseRecorder{statusWriter: statusWriter{ResponseWriter: w}}
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		err := i.store.Release(ctx, claim)
+		if err != nil {
+			utils.Logger(ctx).Error("error releasing idempotency key", "error", err)
+		}
+	}()
+
+	next.ServeHTTP(rec, r)
+
+	status := rec.Status()
+	if status >= http.StatusInternalServerError {
+		return
+	}
+
+	record := claim
+	record.Status = status
+	record.Header = handlerHeaders(before, w.Header())
+	record.Body = rec.body.Bytes()
+	record.ExpiresAt = time.Now().Add(i.options.TTL)
+	err := i.store.Complete(ctx, record)
+	if err != nil {
+		utils.Logger(ctx).Error("error storing idempotent response", "error", err)
+		return
+	}
+	completed = true
+}
+
+func replay(w http.ResponseWriter, record models.IdempotencyRecord) {
+	for name, values := range record.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for _, c := range []byte(key) {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// scopedIdempotencyKey hashes the key together with the client's credentials, so clients can't replay the responses of others
+func scopedIdempotencyKey(r *http.Request, key string) string {
+	h := sha256.New()
+	for _, part := range []string{r.Header.Get("Authorization"), r.Header.Get("X-API-Key"), key} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// requestFingerprint tells apart requests sent with the same key, a key reused on another route is a different request as well
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Content-Type")} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handlerHeaders are the headers set or changed after the snapshot before, they are replayed with the body.
+// Content-Encoding is left out, compression (outside) sets it on the shared header but the stored body is uncompressed.
+func handlerHeaders(before, after http.Header) map[string][]string {
+	headers := make(map[string][]string)
+	for name, values := range after {
+		if name != "Content-Encoding" && !slices.Equal(before[name], values) {
+			headers[name] = slices.Clone(values)
+		}
+	}
+	return headers
+}
+
+// responseRecorder keeps a copy of the body written through it
+type responseRecorder struct {
+	statusWriter
+	body bytes.Buffer
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rec.statusWriter.Write(b)
+	rec.body.Write(b[:n])
+	return n, err
+}
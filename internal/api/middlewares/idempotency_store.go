@@ -0,0 +1,109 @@
+package middlewares
+
+import (
+	"context"
+	"school-management/internal/models"
+	"school-management/internal/repository/sqlconnect"
+	"sync"
+	"time"
+)
+
+const (
+	IdempotencyStoreMemory = "memory"
+	IdempotencyStoreSQL    = "sql"
+)
+
+// IdempotencyStore keeps the requests sent with an Idempotency-Key and their responses
+type IdempotencyStore interface {
+	// Claim stores claim as the in-flight request of its key, unless an unexpired record holds the key already, which is returned
+	Claim(ctx context.Context, claim models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	// Complete stores the response of the request holding the key
+	Complete(ctx context.Context, record models.IdempotencyRecord) error
+	// Release frees the key held by an in-flight request
+	Release(ctx context.Context, record models.IdempotencyRecord) error
+	// DeleteExpired removes the records expired before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// memoryIdempotencyStore keeps the records of this process, retries reaching another replica aren't recognized
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]models.IdempotencyRecord
+}
+
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]models.IdempotencyRecord)}
+}
+
+func (s *memoryIdempotencyStore) Claim(ctx context.Context, claim models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[claim.Key]; ok && existing.ExpiresAt.After(claim.CreatedAt) {
+		return &existing, nil
+	}
+	s.records[claim.Key] = claim
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, record models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.holds(record) {
+		record.Completed = true
+		s.records[record.Key] = record
+	}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, record models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.holds(record) {
+		delete(s.records, record.Key)
+	}
+	return nil
+}
+
+// holds reports whether the key is still held by the in-flight request of record, it may have been claimed again after the lock timeout
+func (s *memoryIdempotencyStore) holds(record models.IdempotencyRecord) bool {
+	existing, ok := s.records[record.Key]
+	return ok && !existing.Completed && existing.Fingerprint == record.Fingerprint && existing.CreatedAt.Equal(record.CreatedAt)
+}
+
+func (s *memoryIdempotencyStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, record := range s.records {
+		if record.ExpiresAt.Before(before) {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
+
+// sqlIdempotencyStore shares the records between replicas in the idempotency_keys table
+type sqlIdempotencyStore struct{}
+
+func NewSQLIdempotencyStore() IdempotencyStore {
+	return sqlIdempotencyStore{}
+}
+
+func (sqlIdempotencyStore) Claim(ctx context.Context, claim models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	return sqlconnect.ClaimIdempotencyKeyDbHandler(ctx, claim)
+}
+
+func (sqlIdempotencyStore) Complete(ctx context.Context, record models.IdempotencyRecord) error {
+	return sqlconnect.CompleteIdempotencyKeyDbHandler(ctx, record)
+}
+
+func (sqlIdempotencyStore) Release(ctx context.Context, record models.IdempotencyRecord) error {
+	return sqlconnect.DeleteIdempotencyKeyDbHandler(ctx, record)
+}
+
+func (sqlIdempotencyStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	return sqlconnect.DeleteExpiredIdempotencyKeysDbHandler(ctx, before)
+}
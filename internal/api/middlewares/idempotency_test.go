@@ -0,0 +1,232 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestIdempotency(t *testing.T, handler http.HandlerFunc) http.Handler {
+	t.Helper()
+	idempotency, err := NewIdempotency(IdempotencyOptions{
+		Routes:      []string{"POST /students", "PATCH /students/{id}"},
+		TTL:         time.Hour,
+		LockTimeout: time.Minute,
+		Store:       IdempotencyStoreMemory,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Recovery(idempotency(handler))
+}
+
+func idempotentRequest(h http.Handler, method, path, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		r.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// createdHandler answers 201 with the number of times it ran
+func createdHandler(calls *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Location", "/students/"+strconv.Itoa(int(n)))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":` + strconv.Itoa(int(n)) + `}`))
+	}
+}
+
+func TestIdempotencyReplaysTheFirstResponse(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestIdempotency(t, createdHandler(&calls))
+
+	first := idempotentRequest(h, http.MethodPost, "/students", "key-1", `[{"first_name":"Ada"}]`)
+	retry := idempotentRequest(h, http.MethodPost, "/students", "key-1", `[{"first_name":"Ada"}]`)
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", calls.Load())
+	}
+	if first.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("expected the first response not to be marked as replayed")
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != `{"id":1}` || retry.Header().Get("Location") != "/students/1" {
+		t.Errorf("expected the stored 201 to be replayed, got %d %q %q", retry.Code, retry.Body.String(), retry.Header().Get("Location"))
+	}
+	if retry.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("expected %s: true, got %q", idempotentReplayedHeader, retry.Header().Get(idempotentReplayedHeader))
+	}
+
+	// other keys, routes without the header and routes without idempotency run the handler each time
+	idempotentRequest(h, http.MethodPost, "/students", "key-2", `[{"first_name":"Ada"}]`)
+	idempotentRequest(h, http.MethodPost, "/students", "", `[{"first_name":"Ada"}]`)
+	idempotentRequest(h, http.MethodPost, "/teachers", "key-1", `[{"first_name":"Ada"}]`)
+	if calls.Load() != 4 {
+		t.Errorf("expected the handler to run 4 times, ran %d times", calls.Load())
+	}
+}
+
+func TestIdempotencyRefusesAKeyReusedForAnotherRequest(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestIdempotency(t, createdHandler(&calls))
+
+	idempotentRequest(h, http.MethodPost, "/students", "key-1", `[{"first_name":"Ada"}]`)
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+	}{
+		{"different body", http.MethodPost, "/students", `[{"first_name":"Grace"}]`},
+		{"different route", http.MethodPatch, "/students/1", `[{"first_name":"Ada"}]`},
+	}
+	for _, tt := range tests {
+		w := idempotentRequest(h, tt.method, tt.path, "key-1", tt.body)
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s: expected 409, got %d", tt.name, w.Code)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected the handler to run once, ran %d times", calls.Load())
+	}
+}
+
+func TestIdempotencyRefusesConcurrentDuplicates(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	h := newTestIdempotency(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- idempotentRequest(h, http.MethodPost, "/students", "key-1", `[]`)
+	}()
+	<-started
+
+	duplicate := idempotentRequest(h, http.MethodPost, "/students", "key-1", `[]`)
+	if duplicate.Code != http.StatusConflict || duplicate.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 409 with Retry-After while the first request is in flight, got %d %q", duplicate.Code, duplicate.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Errorf("expected the first request to complete with 201, got %d", first.Code)
+	}
+	if retry := idempotentRequest(h, http.MethodPost, "/students", "key-1", `[]`); retry.Code != http.StatusCreated || retry.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("expected the completed response to be replayed, got %d", retry.Code)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected the handler to run once, ran %d times", calls.Load())
+	}
+}
+
+func TestIdempotencyReleasesTheKeyOnFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(w http.ResponseWriter)
+	}{
+		{"5xx", func(w http.ResponseWriter) { http.Error(w, "Error connecting to DB", http.StatusServiceUnavailable) }},
+		{"panic", func(w http.ResponseWriter) { panic("nil dereference") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			h := newTestIdempotency(t, func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					tt.fail(w)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			})
+
+			if first := idempotentRequest(h, http.MethodPost, "/students", "key-1", `[]`); first.Code < http.StatusInternalServerError {
+				t.Fatalf("expected the first request to fail, got %d", first.Code)
+			}
+			retry := idempotentRequest(h, http.MethodPost, "/students", "key-1", `[]`)
+			if retry.Code != http.StatusCreated || retry.Header().Get(idempotentReplayedHeader) != "" {
+				t.Errorf("expected the retry to run the handler again, got %d replayed %q", retry.Code, retry.Header().Get(idempotentReplayedHeader))
+			}
+			if calls.Load() != 2 {
+				t.Errorf("expected the handler to run twice, ran %d times", calls.Load())
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyValidation(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestIdempotency(t, createdHandler(&calls))
+
+	for _, key := range []string{strings.Repeat("k", maxIdempotencyKeyLength+1), "key\x7f"} {
+		if w := idempotentRequest(h, http.MethodPost, "/students", key, `[]`); w.Code != http.StatusBadRequest {
+			t.Errorf("key %q: expected 400, got %d", key, w.Code)
+		}
+	}
+	// a structured field string is the same key as its content
+	idempotentRequest(h, http.MethodPost, "/students", `"key-1"`, `[]`)
+	if w := idempotentRequest(h, http.MethodPost, "/students", "key-1", `[]`); w.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("expected the quoted and the bare key to match")
+	}
+}
+
+func TestIdempotencyReplaysForTheRetrysAcceptEncoding(t *testing.T) {
+	body := `[` + strings.Repeat(`{"first_name":"Ada","last_name":"Lovelace"},`, 50) + `{}]`
+	var calls atomic.Int32
+	idempotency := newTestIdempotency(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(body))
+	})
+	// the default pipeline order
+	h := NewCompression(DefaultCompressionOptions())(idempotency)
+
+	send := func(acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/students", strings.NewReader(`[]`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(idempotencyKeyHeader, "key-1")
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if first := send("gzip"); first.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected the first response to be gzip, got %q", first.Header().Get("Content-Encoding"))
+	}
+
+	plain := send("")
+	if plain.Header().Get(idempotentReplayedHeader) != "true" || plain.Header().Get("Content-Encoding") != "" || plain.Body.String() != body {
+		t.Errorf("expected the identity body replayed without Content-Encoding, got %q %q", plain.Header().Get("Content-Encoding"), plain.Body.String())
+	}
+
+	compressed := send("gzip")
+	if compressed.Header().Get(idempotentReplayedHeader) != "true" || compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip replay, got %q", compressed.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := io.ReadAll(zr); err != nil || string(decoded) != body {
+		t.Errorf("expected the gzip replay to decode to the body, got %v %q", err, decoded)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected the handler to run once, ran %d times", calls.Load())
+	}
+}
@@ -0,0 +1,106 @@
+package middlewares
+
+import (
+	"net/http"
+	"school-management/pkg/metrics"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = metrics.Default.NewCounterVec("http_requests_total",
+		"Requests served by route pattern, method and status code.", "route", "method", "status")
+	httpRequestDuration = metrics.Default.NewHistogramVec("http_request_duration_seconds",
+		"Latency of the requests by route pattern, method and status code.", metrics.DefaultBuckets, "route", "method", "status")
+	httpRequestsInFlight = metrics.Default.NewGauge("http_requests_in_flight",
+		"Requests currently being served.")
+	rateLimitRejections = metrics.Default.NewCounterVec("rate_limit_rejections_total",
+		"Requests answered with 429 by the rate limiter, by policy.", "policy")
+)
+
+// unmatchedRoute labels requests that didn't match a route, so scanners probing random paths can't blow up the series
+const unmatchedRoute = "unmatched"
+
+type MetricsOptions struct {
+	// Routes are the ServeMux patterns of the router ("GET /api/v1/students/{id}"), requests are labeled by them
+	Routes []string
+}
+
+type metricsMiddleware struct {
+	routes routeMatcher
+}
+
+func NewMetrics(options MetricsOptions) func(http.Handler) http.Handler {
+	m := &metricsMiddleware{routes: newRouteMatcher(options.Routes)}
+	return m.middleware
+}
+
+func (m *metricsMiddleware) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := m.routes.route(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		method := methodLabel(r.Method)
+		status := strconv.Itoa(sw.Status())
+		httpRequests.With(route, method, status).Inc()
+		httpRequestDuration.With(route, method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel keeps the standard methods, any other token is a valid method too and would add a series per request
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// statusWriter records the status code sent by the handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	// informational responses (eg. 103 Early Hints) are followed by the real one
+	if sw.status == 0 && code >= 200 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Status is the status code sent, 200 if the handler wrote nothing at all
+func (sw *statusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"school-management/pkg/metrics"
+	"strings"
+	"testing"
+)
+
+func TestMetricsLabelsNonStandardMethodsAsOther(t *testing.T) {
+	h := NewMetrics(MetricsOptions{Routes: []string{"GET /metrics-test"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, method := range []string{"GET", "FOO", "BAR"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/metrics-test", nil))
+	}
+
+	var b strings.Builder
+	metrics.Default.WriteText(&b)
+	out := b.String()
+	if !strings.Contains(out, `http_requests_total{route="/metrics-test",method="GET",status="200"} 1`) {
+		t.Errorf("expected the GET series, got\n%s", out)
+	}
+	if !strings.Contains(out, `http_requests_total{route="unmatched",method="OTHER",status="200"} 2`) {
+		t.Errorf("expected FOO and BAR to share the OTHER series, got\n%s", out)
+	}
+	if strings.Contains(out, `method="FOO"`) {
+		t.Errorf("expected no FOO series, got\n%s", out)
+	}
+}
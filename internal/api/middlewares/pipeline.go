@@ -0,0 +1,112 @@
+package middlewares
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"school-management/pkg/utils"
+	"slices"
+	"strings"
+)
+
+// PipelineConfig decides which global middlewares wrap the router, in which order and with which options
+type PipelineConfig struct {
+	// Middlewares in the order they see a request, the first one is the outermost
+	Middlewares []string
+	Cors        CorsOptions
+	Auth        AuthOptions
+	RateLimit   RateLimiterOptions
+	Hpp         HPPOptions
+	Compression CompressionOptions
+	Metrics     MetricsOptions
+	Tracing     TracingOptions
+	BodyLimit   BodyLimitOptions
+	Idempotency IdempotencyOptions
+	Caching     CachingOptions
+}
+
+// pipelineMiddlewares are the middlewares a pipeline can be built from
+var pipelineMiddlewares = map[string]func(config PipelineConfig) (utils.Middleware, error){
+	"cors": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewCors(config.Cors), nil
+	},
+	"authentication": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewAuthentication(config.Auth), nil
+	},
+	"rate_limit": func(config PipelineConfig) (utils.Middleware, error) {
+		rl, err := NewRateLimiter(config.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		return rl.Middleware, nil
+	},
+	"request_id": func(config PipelineConfig) (utils.Middleware, error) {
+		return RequestID, nil
+	},
+	"tracing": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewTracing(config.Tracing), nil
+	},
+	"request_logger": func(config PipelineConfig) (utils.Middleware, error) {
+		return RequestLogger, nil
+	},
+	"metrics": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewMetrics(config.Metrics), nil
+	},
+	"recovery": func(config PipelineConfig) (utils.Middleware, error) {
+		return Recovery, nil
+	},
+	"body_limit": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewBodyLimit(config.BodyLimit), nil
+	},
+	"idempotency": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewIdempotency(config.Idempotency)
+	},
+	"caching": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewCaching(config.Caching), nil
+	},
+	"response_time": func(config PipelineConfig) (utils.Middleware, error) {
+		return ResponseTime, nil
+	},
+	"security_headers": func(config PipelineConfig) (utils.Middleware, error) {
+		return SecurityHeaders, nil
+	},
+	"compression": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewCompression(config.Compression), nil
+	},
+	"hpp": func(config PipelineConfig) (utils.Middleware, error) {
+		return NewHpp(config.Hpp)
+	},
+}
+
+// BuildPipeline wraps handler with the configured middlewares and logs the effective chain
+func BuildPipeline(handler http.Handler, config PipelineConfig) (http.Handler, error) {
+	middlewares := make([]utils.Middleware, 0, len(config.Middlewares))
+	for i, name := range config.Middlewares {
+		if slices.Contains(config.Middlewares[:i], name) {
+			return nil, fmt.Errorf("middleware %q is listed twice", name)
+		}
+		build, ok := pipelineMiddlewares[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q, available: %s", name, strings.Join(pipelineMiddlewareNames(), ", "))
+		}
+		middleware, err := build(config)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", name, err)
+		}
+		middlewares = append(middlewares, middleware)
+	}
+
+	// ApplyMiddlewares wraps in list order, so the first middleware of the config has to be applied last
+	slices.Reverse(middlewares)
+	slog.Info("middleware chain", "chain", strings.Join(append(slices.Clone(config.Middlewares), "router"), " -> "))
+	return utils.ApplyMiddlewares(handler, middlewares...), nil
+}
+
+func pipelineMiddlewareNames() []string {
+	names := make([]string, 0, len(pipelineMiddlewares))
+	for name := range pipelineMiddlewares {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,177 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"math"
+	"school-management/internal/models"
+	"school-management/internal/repository/sqlconnect"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreSQL    = "sql"
+
+	rateLimitShards = 64
+)
+
+// RateLimitDecision is the outcome of a request against its policy
+type RateLimitDecision struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the quota is fully available again
+	RetryAfter time.Duration // until the next request is allowed, only set when denied
+}
+
+// RateLimitStore keeps the rate limit state of the clients
+type RateLimitStore interface {
+	// Take counts a request of key against policy
+	Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitDecision, error)
+	// EvictIdle removes the state of clients last seen before the given time
+	EvictIdle(ctx context.Context, before time.Time) error
+}
+
+// memoryRateLimitStore keeps the states of this process in shards, each with its own lock, so clients rarely wait for each other
+type memoryRateLimitStore struct {
+	shards [rateLimitShards]struct {
+		mu     sync.Mutex
+		states map[string]*models.RateLimitState
+	}
+}
+
+func NewMemoryRateLimitStore() RateLimitStore {
+	s := &memoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].states = make(map[string]*models.RateLimitState)
+	}
+	return s
+}
+
+func (s *memoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitDecision, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitShards]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	state, ok := shard.states[key]
+	if !ok {
+		state = newRateLimitState(policy, now)
+		shard.states[key] = state
+	}
+	return takeRateLimit(state, policy, now), nil
+}
+
+func (s *memoryRateLimitStore) EvictIdle(ctx context.Context, before time.Time) error {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for key, state := range shard.states {
+			if state.LastSeen.Before(before) {
+				delete(shard.states, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return nil
+}
+
+// sqlRateLimitStore shares the states between replicas in the rate_limits table
+type sqlRateLimitStore struct{}
+
+func NewSQLRateLimitStore() RateLimitStore {
+	return sqlRateLimitStore{}
+}
+
+func (sqlRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitDecision, error) {
+	// keys can hold api keys of any length, the table stores their hash
+	hash := sha256.Sum256([]byte(key))
+
+	var decision RateLimitDecision
+	err := sqlconnect.UpdateRateLimitDbHandler(ctx, hex.EncodeToString(hash[:]), func(state *models.RateLimitState, isNew bool) {
+		if isNew {
+			*state = *newRateLimitState(policy, now)
+		}
+		decision = takeRateLimit(state, policy, now)
+	})
+	return decision, err
+}
+
+func (sqlRateLimitStore) EvictIdle(ctx context.Context, before time.Time) error {
+	return sqlconnect.DeleteIdleRateLimitsDbHandler(ctx, before)
+}
+
+func newRateLimitState(policy RateLimitPolicy, now time.Time) *models.RateLimitState {
+	return &models.RateLimitState{Tokens: float64(policy.Limit), LastRefill: now, WindowStart: now}
+}
+
+func takeRateLimit(state *models.RateLimitState, policy RateLimitPolicy, now time.Time) RateLimitDecision {
+	state.LastSeen = now
+	if policy.Algorithm == AlgorithmSlidingWindow {
+		return takeSlidingWindow(state, policy, now)
+	}
+	return takeTokenBucket(state, policy, now)
+}
+
+func takeTokenBucket(state *models.RateLimitState, policy RateLimitPolicy, now time.Time) RateLimitDecision {
+	limit := float64(policy.Limit)
+	perToken := policy.Window / time.Duration(policy.Limit)
+
+	elapsed := now.Sub(state.LastRefill)
+	state.Tokens = math.Min(limit, state.Tokens+elapsed.Seconds()/perToken.Seconds())
+	state.LastRefill = now
+
+	decision := RateLimitDecision{Allowed: state.Tokens >= 1}
+	if decision.Allowed {
+		state.Tokens--
+	} else {
+		decision.RetryAfter = time.Duration((1 - state.Tokens) * float64(perToken))
+	}
+	decision.Remaining = int(state.Tokens)
+	decision.Reset = time.Duration((limit - state.Tokens) * float64(perToken))
+	return decision
+}
+
+// takeSlidingWindow approximates a sliding window by weighting the count of the previous fixed window
+// with the part of it still inside the sliding window
+func takeSlidingWindow(state *models.RateLimitState, policy RateLimitPolicy, now time.Time) RateLimitDecision {
+	elapsed := now.Sub(state.WindowStart)
+	if elapsed >= policy.Window {
+		windows := elapsed / policy.Window
+		state.PrevCount = state.CurrCount
+		if windows > 1 {
+			state.PrevCount = 0
+		}
+		state.CurrCount = 0
+		state.WindowStart = state.WindowStart.Add(windows * policy.Window)
+		elapsed = now.Sub(state.WindowStart)
+	}
+
+	prevWeight := 1 - elapsed.Seconds()/policy.Window.Seconds()
+	estimate := float64(state.PrevCount)*prevWeight + float64(state.CurrCount)
+
+	decision := RateLimitDecision{Allowed: estimate+1 <= float64(policy.Limit)}
+	if decision.Allowed {
+		state.CurrCount++
+		estimate++
+	} else {
+		if state.CurrCount+1 > policy.Limit {
+			// the current window alone is full, quota frees up once enough of it slid out of the next window
+			decision.RetryAfter = policy.Window - elapsed + time.Duration((1-float64(policy.Limit-1)/float64(state.CurrCount))*float64(policy.Window))
+		} else {
+			// wait until enough of the previous window slid out
+			decision.RetryAfter = time.Duration((1-float64(policy.Limit-1-state.CurrCount)/float64(state.PrevCount))*float64(policy.Window)) - elapsed
+		}
+	}
+	decision.Remaining = max(0, policy.Limit-int(math.Ceil(estimate)))
+	decision.Reset = policy.Window - elapsed
+	if state.CurrCount > 0 {
+		decision.Reset += policy.Window
+	}
+	return decision
+}
@@ -1,60 +1,237 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
-	"sync"
+	"school-management/internal/lifecycle"
+	"school-management/pkg/utils"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmSlidingWindow = "sliding_window"
+
+	KeyIP     = "ip"
+	KeyAPIKey = "api_key"
+	KeyExec   = "exec"
+)
+
+// RateLimitPolicy allows Limit requests per Window and client, clients are told apart by Key
+type RateLimitPolicy struct {
+	// Name keys the counts, routes with policies of the same name share them (eg. the login of every version)
+	Name string
+	// Algorithm is token_bucket (bursts up to Limit, refilled evenly over Window) or sliding_window
+	Algorithm string
+	Limit     int
+	Window    time.Duration
+	// Key is ip, api_key (a verified X-API-Key) or exec (the logged in exec), the last two fall back to the ip.
+	// They are set by the authentication middleware, which has to run before the rate limiter.
+	Key string
+}
+
+type RateLimiterOptions struct {
+	Default RateLimitPolicy
+	// Routes are ServeMux patterns ("POST /api/v1/execs/login") with their own policy
+	Routes map[string]RateLimitPolicy
+	// TrustedProxies are the CIDRs whose X-Forwarded-For header is believed
+	TrustedProxies []string
+	// IdleTimeout evicts the state of clients without requests for that long
+	IdleTimeout time.Duration
+	// Store is memory (per process) or sql (shared by all replicas through the database)
+	Store string
+}
+
 type rateLimiter struct {
-	mu        sync.Mutex
-	visitors  map[string]int
-	limit     int
-	resetTime time.Duration
+	defaultPolicy  RateLimitPolicy
+	routes         *http.ServeMux // only used to match the route patterns of the policies
+	policies       map[string]RateLimitPolicy
+	trustedProxies []*net.IPNet
+	store          RateLimitStore
 }
 
-func NewRateLimiter(limit int, resetTime time.Duration) *rateLimiter {
+func NewRateLimiter(options RateLimiterOptions) (*rateLimiter, error) {
 	rl := &rateLimiter{
-		visitors:  make(map[string]int),
-		limit:     limit,
-		resetTime: resetTime,
+		defaultPolicy: options.Default,
+		routes:        http.NewServeMux(),
+		policies:      make(map[string]RateLimitPolicy),
+	}
+
+	switch options.Store {
+	case RateLimitStoreMemory, "":
+		rl.store = NewMemoryRateLimitStore()
+	case RateLimitStoreSQL:
+		rl.store = NewSQLRateLimitStore()
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", options.Store)
+	}
+
+	if rl.defaultPolicy.Name == "" {
+		rl.defaultPolicy.Name = "default"
+	}
+
+	err := validatePolicy(rl.defaultPolicy)
+	if err != nil {
+		return nil, err
 	}
-	// start the reset routine
-	go rl.ResetVisitorCount()
-	return rl
+	named := map[string]RateLimitPolicy{rl.defaultPolicy.Name: rl.defaultPolicy}
+	for pattern, policy := range options.Routes {
+		if policy.Name == "" {
+			policy.Name = pattern
+		}
+		err = validatePolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("policy of %q: %w", pattern, err)
+		}
+		// shared counts only make sense with the same limits
+		if other, ok := named[policy.Name]; ok && other != policy {
+			return nil, fmt.Errorf("policy of %q: another policy named %q has different settings", pattern, policy.Name)
+		}
+		named[policy.Name] = policy
+		rl.policies[pattern] = policy
+		rl.routes.Handle(pattern, http.NotFoundHandler())
+	}
+
+	for _, cidr := range options.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", cidr)
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+		rl.trustedProxies = append(rl.trustedProxies, network)
+	}
+
+	// drop idle clients, it stops on shutdown
+	if options.IdleTimeout > 0 {
+		lifecycle.Go(func(ctx context.Context) {
+			rl.evictIdle(ctx, options.IdleTimeout)
+		})
+	}
+	return rl, nil
 }
 
-func (rl *rateLimiter) ResetVisitorCount() {
+func (rl *rateLimiter) evictIdle(ctx context.Context, idleTimeout time.Duration) {
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
 	for {
-		time.Sleep(rl.resetTime)
-		rl.mu.Lock()
-		rl.visitors = make(map[string]int)
-		rl.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := rl.store.EvictIdle(ctx, now.Add(-idleTimeout))
+			if err != nil {
+				slog.Error("error evicting idle rate limits", "error", err)
+			}
+		}
 	}
 }
 
+func validatePolicy(policy RateLimitPolicy) error {
+	switch {
+	case policy.Algorithm != AlgorithmTokenBucket && policy.Algorithm != AlgorithmSlidingWindow:
+		return fmt.Errorf("unknown algorithm %q", policy.Algorithm)
+	case policy.Key != KeyIP && policy.Key != KeyAPIKey && policy.Key != KeyExec:
+		return fmt.Errorf("unknown key %q", policy.Key)
+	case policy.Limit <= 0 || policy.Window <= 0:
+		return fmt.Errorf("limit and window must be positive")
+	}
+	return nil
+}
+
 func (rl *rateLimiter) Middleware(next http.Handler) http.Handler {
-	fmt.Println("Rate Limiter Middleware...")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Rate Limiter Middleware begin returned...")
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
-
-		// get requestIP and increment its count
-		visitorIP := r.RemoteAddr // We might extract IP in a more sophisticated way
-		rl.visitors[visitorIP]++
-		log.Printf("RATE LIMITER middleware - Visitor count of %v is %v\n", visitorIP, rl.visitors[visitorIP])
+		policy := rl.defaultPolicy
+		if _, pattern := rl.routes.Handler(r); pattern != "" {
+			policy = rl.policies[pattern]
+		}
 
-		if rl.visitors[visitorIP] > rl.limit {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		// the client's state is only locked for the decision, not while the request is served
+		decision, err := rl.store.Take(r.Context(), policy.Name+"|"+rl.clientKey(r, policy.Key), policy, time.Now())
+		if err != nil {
+			// an unavailable store must not take the api down with it, the request is let through
+			utils.Logger(r.Context()).Error("error checking rate limit, letting the request through", "error", err)
+			next.ServeHTTP(w, r)
 			return
 		}
 
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(math.Ceil(policy.Window.Seconds()))))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+
+		if !decision.Allowed {
+			rateLimitRejections.With(policy.Name).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			utils.WriteProblem(w, r, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
 		next.ServeHTTP(w, r)
-		fmt.Println("Rate Limiter Middleware ends...")
 	})
 }
 
-// mutexes prevents race conditions by making sure that “Only one goroutine may execute the code between these two lines at a time.”
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientKey only trusts the identities verified by the authentication middleware, an unverified X-API-Key
+// header would let clients dodge the limits by sending a new key with every request
+func (rl *rateLimiter) clientKey(r *http.Request, key string) string {
+	switch key {
+	case KeyAPIKey:
+		if name, ok := r.Context().Value(apiClientKey{}).(string); ok {
+			return "key:" + name
+		}
+	case KeyExec:
+		if id, ok := r.Context().Value(execIDKey{}).(int); ok {
+			return execPrincipal(id)
+		}
+	}
+	return "ip:" + rl.clientIP(r)
+}
+
+// clientIP is the remote address without port, X-Forwarded-For is only followed through trusted proxies
+func (rl *rateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !rl.isTrustedProxy(host) {
+		return host
+	}
+
+	// the rightmost entries were added by our proxies, the first untrusted one is the client
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !rl.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return host
+}
+
+func (rl *rateLimiter) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range rl.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"school-management/pkg/utils"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestRateLimiter(t *testing.T, key string) http.Handler {
+	t.Helper()
+	login := RateLimitPolicy{Name: "login", Algorithm: AlgorithmSlidingWindow, Limit: 2, Window: time.Minute, Key: KeyIP}
+	rl, err := NewRateLimiter(RateLimiterOptions{
+		Default: RateLimitPolicy{Algorithm: AlgorithmSlidingWindow, Limit: 2, Window: time.Minute, Key: key},
+		Routes:  map[string]RateLimitPolicy{"POST /api/v1/execs/login": login, "POST /execs/login": login},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthentication(AuthOptions{JWTSecret: testSecret, APIKeys: map[string]string{"reporting": "reporting-key"}})
+	return auth(rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+}
+
+func rateLimitedRequest(h http.Handler, method, path string, header map[string]string) int {
+	r := httptest.NewRequest(method, path, nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRateLimiterIgnoresUnverifiedAPIKeys(t *testing.T) {
+	h := newTestRateLimiter(t, KeyAPIKey)
+
+	// a new made up key with every request still counts against the ip
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		code := rateLimitedRequest(h, http.MethodGet, "/api/v1/students", map[string]string{"X-API-Key": "random-" + strconv.Itoa(i)})
+		if code != expected {
+			t.Errorf("request %d: expected %d, got %d", i, expected, code)
+		}
+	}
+	// a verified key has counts of its own
+	if code := rateLimitedRequest(h, http.MethodGet, "/api/v1/students", map[string]string{"X-API-Key": "reporting-key"}); code != http.StatusOK {
+		t.Errorf("verified key: expected 200, got %d", code)
+	}
+}
+
+func TestRateLimiterKeysOnLoggedInExec(t *testing.T) {
+	h := newTestRateLimiter(t, KeyExec)
+	token := func(id int) string {
+		token, err := utils.SignToken(utils.TokenClaims{Subject: strconv.Itoa(id), ExpiresAt: time.Now().Add(time.Minute).Unix()}, []byte(testSecret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+
+	for i := 0; i < 2; i++ {
+		rateLimitedRequest(h, http.MethodGet, "/api/v1/students", map[string]string{"Authorization": token(1)})
+	}
+	if code := rateLimitedRequest(h, http.MethodGet, "/api/v1/students", map[string]string{"Authorization": token(1)}); code != http.StatusTooManyRequests {
+		t.Errorf("exec 1: expected 429, got %d", code)
+	}
+	if code := rateLimitedRequest(h, http.MethodGet, "/api/v1/students", map[string]string{"Authorization": token(2)}); code != http.StatusOK {
+		t.Errorf("exec 2 from the same ip: expected 200, got %d", code)
+	}
+	// a forged token falls back to the ip, which hasn't been used yet
+	if code := rateLimitedRequest(h, http.MethodGet, "/api/v1/students", map[string]string{"Authorization": token(1) + "x"}); code != http.StatusOK {
+		t.Errorf("invalid token: expected 200, got %d", code)
+	}
+}
+
+func TestLoginRoutesShareTheirPolicy(t *testing.T) {
+	h := newTestRateLimiter(t, KeyIP)
+
+	for i, path := range []string{"/api/v1/execs/login", "/execs/login", "/api/v1/execs/login", "/execs/login"} {
+		expected := http.StatusOK
+		if i >= 2 {
+			expected = http.StatusTooManyRequests
+		}
+		if code := rateLimitedRequest(h, http.MethodPost, path, nil); code != expected {
+			t.Errorf("attempt %d on %s: expected %d, got %d", i, path, expected, code)
+		}
+	}
+}
+
+func TestRateLimiterRefusesDifferentPoliciesOfTheSameName(t *testing.T) {
+	_, err := NewRateLimiter(RateLimiterOptions{
+		Default: RateLimitPolicy{Algorithm: AlgorithmTokenBucket, Limit: 100, Window: time.Minute, Key: KeyIP},
+		Routes: map[string]RateLimitPolicy{
+			"POST /api/v1/execs/login": {Name: "login", Algorithm: AlgorithmSlidingWindow, Limit: 5, Window: time.Minute, Key: KeyIP},
+			"POST /execs/login":        {Name: "login", Algorithm: AlgorithmSlidingWindow, Limit: 50, Window: time.Minute, Key: KeyIP},
+		},
+	})
+	if err == nil {
+		t.Error("expected an error for two login policies with different limits")
+	}
+}
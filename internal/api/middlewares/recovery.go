@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"school-management/pkg/utils"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recovery turns a panicking handler into a 500 problem response and logs the panic with its stack trace and the
+// request's context. It has to run inside request_logger, otherwise the log line misses the request id.
+func Recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// handlers abort on purpose with it, net/http closes the connection without logging
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			utils.Logger(r.Context()).Error("panic serving request", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			span := trace.SpanFromContext(r.Context())
+			span.RecordError(fmt.Errorf("panic: %v", recovered))
+			span.SetStatus(codes.Error, "panic")
+
+			// a started response can't be turned into an error anymore, the client sees the connection drop instead
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			utils.WriteProblem(w, r, http.StatusInternalServerError, "The server failed to handle the request")
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
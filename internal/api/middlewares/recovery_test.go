@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"school-management/pkg/utils"
+	"testing"
+)
+
+func TestRecoveryAnswersPanicsWith500(t *testing.T) {
+	h := RequestID(Recovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var student *struct{ Name string }
+		w.Header().Set("X-Test", student.Name) // nil dereference
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/students/1", nil)
+	r.Header.Set("X-Request-ID", "recovery-test")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected a problem response, got %q", ct)
+	}
+	var problem utils.Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != http.StatusInternalServerError || problem.RequestID != "recovery-test" || problem.Instance != "/api/v1/students/1" {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+}
+
+func TestRecoveryAbortsStartedResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"panic after the header", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			panic("export failed")
+		}},
+		{"deliberate abort", func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				recovered := recover()
+				if err, ok := recovered.(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
+					t.Errorf("expected net/http to see http.ErrAbortHandler, got %v", recovered)
+				}
+			}()
+			Recovery(tt.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net/http"
+	"school-management/pkg/utils"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds ids passed in by clients or proxies, they end up in logs and sql comments
+const maxRequestIDLength = 128
+
+// RequestID keeps the X-Request-ID of the caller (eg. a gateway that already assigned one) or generates a ULID,
+// stores it on the context and echoes it in the response
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = ulid.Make().String()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(utils.ContextWithRequestID(r.Context(), requestID)))
+	})
+}
+
+// isValidRequestID accepts ids of letters, digits and - _ . : so they can't break log lines or sql comments
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, ch := range requestID {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-', ch == '_', ch == '.', ch == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"school-management/pkg/utils"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// principal is who sends the request, auth runs after the logger is attached so it is filled in later
+type principal struct {
+	name atomic.Pointer[string]
+}
+
+func (p *principal) String() string {
+	if name := p.name.Load(); name != nil {
+		return *name
+	}
+	return "anonymous"
+}
+
+// principalHandler adds the principal when a record is logged, slog's own handlers resolve the attrs of
+// Logger.With right away, which would freeze it at anonymous
+type principalHandler struct {
+	slog.Handler
+	principal *principal
+}
+
+func (h principalHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(slog.String("principal", h.principal.String()))
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h principalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return principalHandler{Handler: h.Handler.WithAttrs(attrs), principal: h.principal}
+}
+
+func (h principalHandler) WithGroup(name string) slog.Handler {
+	return principalHandler{Handler: h.Handler.WithGroup(name), principal: h.principal}
+}
+
+type principalKey struct{}
+
+// setPrincipal names the principal in the request logger, if there is one
+func setPrincipal(ctx context.Context, name string) {
+	if p, ok := ctx.Value(principalKey{}).(*principal); ok {
+		p.name.Store(&name)
+	}
+}
+
+// RequestLogger attaches a logger carrying the request id, method, path and principal to the request context,
+// handlers and the repository log through utils.Logger(ctx)
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := &principal{}
+		if name, ok := utils.VerifiedClientName(r); ok {
+			name = "client:" + name
+			p.name.Store(&name)
+		}
+
+		// the id comes from the request_id middleware, without it the logger still correlates its own lines
+		ctx := r.Context()
+		requestID := utils.RequestID(ctx)
+		if requestID == "" {
+			requestID = ulid.Make().String()
+			ctx = utils.ContextWithRequestID(ctx, requestID)
+		}
+
+		logger := slog.New(principalHandler{Handler: slog.Default().Handler(), principal: p}).With(
+			slog.String("request_id", requestID),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+		)
+		// set by the tracing middleware, lets the logs of a request be found from its trace
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+			logger = logger.With(slog.String("trace_id", spanContext.TraceID().String()))
+		}
+		ctx = utils.ContextWithLogger(ctx, logger)
+		ctx = context.WithValue(ctx, principalKey{}, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// execPrincipal is the principal name of a logged in exec
+func execPrincipal(id int) string {
+	return "exec:" + strconv.Itoa(id)
+}
@@ -1,41 +1,63 @@
 package middlewares
 
 import (
-	"fmt"
-	"log"
 	"net/http"
+	"school-management/pkg/utils"
 	"time"
 )
 
 func ResponseTime(next http.Handler) http.Handler {
-	fmt.Println("Response Time Middleware ...")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Response Time Middleware being returned ...")
-		start := time.Now()
-
-		// create a custom ResponseWriter to capture the status code
+		// create a custom ResponseWriter to capture the status code and set the header before it is sent
 		wrappedWriter := &responseWriter{
 			ResponseWriter: w,
-			status:         http.StatusOK,
+			start:          time.Now(),
 		}
 
 		next.ServeHTTP(wrappedWriter, r)
 
-		// calculate the duration and log it
-		duration := time.Since(start)
-		wrappedWriter.Header().Set("X-Response-Time", duration.String()) // needs to be done before handing over control to next
-		log.Printf("Method: %s, URL: %s, Status: %d, Duration: %v\n", r.Method, r.URL, wrappedWriter.status, duration.String())
-		fmt.Println("Response Time Middleware ends ...")
+		duration := time.Since(wrappedWriter.start)
+		utils.Logger(r.Context()).Info("request served", "status", wrappedWriter.Status(), "duration", duration.String())
 	})
 }
 
-// responseWriter
+// responseWriter sets X-Response-Time with the time until the headers were written,
+// headers can't be changed once the body is being written
 type responseWriter struct {
 	http.ResponseWriter
+	start  time.Time
 	status int
 }
 
-func (rw responseWriter) WriteHeader(code int) {
-	rw.status = code
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.status == 0 && code >= 200 {
+		rw.status = code
+		rw.Header().Set("X-Response-Time", time.Since(rw.start).String())
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Status is the status code sent, 200 if the handler wrote nothing at all
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+)
+
+// routeMatcher finds the route pattern of a request for middlewares running before the router,
+// requests are labeled by it instead of by their path (metrics, span names)
+type routeMatcher struct {
+	mux *http.ServeMux // only used to match the route patterns
+}
+
+// newRouteMatcher takes ServeMux patterns with their method ("GET /api/v1/students/{id}")
+func newRouteMatcher(patterns []string) routeMatcher {
+	m := routeMatcher{mux: http.NewServeMux()}
+	for _, pattern := range patterns {
+		m.mux.Handle(pattern, http.NotFoundHandler())
+	}
+	return m
+}
+
+// route is the path of the matched pattern ("/api/v1/students/{id}"), empty if no route matches
+func (m routeMatcher) route(r *http.Request) string {
+	_, pattern := m.mux.Handler(r)
+	if _, path, found := strings.Cut(pattern, " "); found {
+		return path
+	}
+	return pattern
+}
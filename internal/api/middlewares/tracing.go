@@ -0,0 +1,69 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"school-management/pkg/utils"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("school-management/internal/api/middlewares")
+
+type TracingOptions struct {
+	// Routes are the ServeMux patterns of the router, spans are named by them ("GET /api/v1/students/{id}")
+	Routes []string
+}
+
+// NewTracing starts a server span for every request, continuing the trace of an incoming traceparent header.
+// Handlers, the repository and the sql driver add their spans below it through the request context.
+func NewTracing(options TracingOptions) func(http.Handler) http.Handler {
+	routes := newRouteMatcher(options.Routes)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			attrs := []attribute.KeyValue{
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLScheme(scheme),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			}
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				attrs = append(attrs, semconv.ClientAddress(host))
+			}
+			if requestID := utils.RequestID(ctx); requestID != "" {
+				attrs = append(attrs, attribute.String("request_id", requestID))
+			}
+
+			// unmatched paths share one span name, the path is still an attribute
+			name := r.Method
+			if route := routes.route(r); route != "" {
+				name += " " + route
+				attrs = append(attrs, semconv.HTTPRoute(route))
+			}
+
+			ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+			defer span.End()
+
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			status := sw.Status()
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			// 4xx are the client's fault, the server span only fails on 5xx
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
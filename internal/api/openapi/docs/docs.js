@@ -0,0 +1,70 @@
+// renders /openapi.json grouped by tag, kept dependency free so it works with the api's Content-Security-Policy
+(async function () {
+	const main = document.getElementById("operations");
+	const spec = await (await fetch("/openapi.json")).json();
+	document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+
+	const el = (tag, attrs, ...children) => {
+		const node = document.createElement(tag);
+		Object.assign(node, attrs || {});
+		children.forEach((child) => node.append(child));
+		return node;
+	};
+
+	const resolve = (schema) => {
+		if (schema && schema.$ref) {
+			return spec.components.schemas[schema.$ref.split("/").pop()];
+		}
+		return schema;
+	};
+
+	const expand = (schema, depth = 0) => {
+		schema = resolve(schema);
+		if (!schema || depth > 4) return schema;
+		const copy = Object.assign({}, schema);
+		if (copy.items) copy.items = expand(copy.items, depth + 1);
+		if (copy.properties) {
+			copy.properties = Object.fromEntries(Object.entries(copy.properties).map(([k, v]) => [k, expand(v, depth + 1)]));
+		}
+		return copy;
+	};
+
+	const groups = {};
+	for (const [path, item] of Object.entries(spec.paths)) {
+		for (const [method, op] of Object.entries(item)) {
+			const tag = (op.tags && op.tags[0]) || "other";
+			(groups[tag] = groups[tag] || []).push({ path, method, op });
+		}
+	}
+
+	for (const tag of Object.keys(groups).sort()) {
+		main.append(el("h2", { textContent: tag }));
+		groups[tag].sort((a, b) => a.path.localeCompare(b.path));
+		for (const { path, method, op } of groups[tag]) {
+			const body = el("div", { className: "body" }, el("p", { textContent: op.description || "" }));
+
+			if (op.parameters && op.parameters.length) {
+				const table = el("table", {}, el("tr", {}, el("th", { textContent: "name" }), el("th", { textContent: "in" }), el("th", { textContent: "type" }), el("th", { textContent: "description" })));
+				for (const p of op.parameters) {
+					table.append(el("tr", {}, el("td", { textContent: p.name + (p.required ? " *" : "") }), el("td", { textContent: p.in }), el("td", { textContent: JSON.stringify((p.schema || {}).type || "") }), el("td", { textContent: p.description || "" })));
+				}
+				body.append(el("h4", { textContent: "Parameters" }), table);
+			}
+
+			if (op.requestBody) {
+				for (const [type, media] of Object.entries(op.requestBody.content)) {
+					body.append(el("h4", { textContent: "Request body (" + type + ")" }), el("pre", { textContent: JSON.stringify(expand(media.schema), null, 2) }));
+				}
+			}
+
+			for (const [status, response] of Object.entries(op.responses || {})) {
+				body.append(el("h4", { textContent: status + " " + response.description }));
+				for (const [type, media] of Object.entries(response.content || {})) {
+					body.append(el("pre", { textContent: type + "\n" + JSON.stringify(expand(media.schema), null, 2) }));
+				}
+			}
+
+			main.append(el("details", {}, el("summary", {}, el("span", { className: "method " + method, textContent: method.toUpperCase() }), path + "  " + (op.summary || "")), body));
+		}
+	}
+})();
@@ -0,0 +1,56 @@
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"school-management/pkg/utils"
+	"sync"
+	"time"
+)
+
+//go:embed docs
+var docsFS embed.FS
+
+// JSONHandler serves the document built by build, it is only built once on the first request.
+// The document only changes with a deploy, its build time is the Last-Modified.
+func JSONHandler(build func() (*Document, error)) http.HandlerFunc {
+	var once sync.Once
+	var doc []byte
+	var builtAt time.Time
+	var buildErr error
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			var document *Document
+			document, buildErr = build()
+			if buildErr == nil {
+				doc, buildErr = json.MarshalIndent(document, "", "  ")
+			}
+			builtAt = time.Now()
+		})
+		if buildErr != nil {
+			utils.WriteProblem(w, r, http.StatusInternalServerError, buildErr.Error())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Last-Modified", builtAt.UTC().Format(http.TimeFormat))
+		w.Write(doc)
+	}
+}
+
+// DocsHandler serves the embedded docs page (GET /docs) and its assets (GET /docs/{file})
+func DocsHandler() http.Handler {
+	sub, _ := fs.Sub(docsFS, "docs")
+	fileServer := http.StripPrefix("/docs/", http.FileServerFS(sub))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/docs" || r.URL.Path == "/docs/" {
+			http.ServeFileFS(w, r, sub, "index.html")
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,267 @@
+package openapi
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Document is the subset of an OpenAPI 3.1 document the api needs
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type PathItem struct {
+	Get     *Operation `json:"get,omitempty"`
+	Put     *Operation `json:"put,omitempty"`
+	Post    *Operation `json:"post,omitempty"`
+	Delete  *Operation `json:"delete,omitempty"`
+	Options *Operation `json:"options,omitempty"`
+	Head    *Operation `json:"head,omitempty"`
+	Patch   *Operation `json:"patch,omitempty"`
+}
+
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        interface{}        `json:"type,omitempty"` // a type name or a list of them, eg. ["string", "null"]
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+
+	model reflect.Type // set by Ref, registered under components when the route is added
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// RouteSpec is the documentation of a single route, path parameters are derived from the pattern
+type RouteSpec struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	Parameters  []Parameter
+	RequestBody *RequestBody
+	Responses   map[string]Response
+}
+
+func NewDocument(title, version string) *Document {
+	return &Document{
+		OpenAPI:    "3.1.0",
+		Info:       Info{Title: title, Version: version},
+		Paths:      make(map[string]*PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// AddRoute documents a ServeMux pattern ("GET /students/{id}") with its spec
+func (d *Document) AddRoute(pattern string, spec RouteSpec) error {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("route %q has no method", pattern)
+	}
+	path = strings.ReplaceAll(path, "...}", "}")
+
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+
+	op := &Operation{
+		OperationID: operationID(method, path),
+		Summary:     spec.Summary,
+		Description: spec.Description,
+		Tags:        spec.Tags,
+		Deprecated:  spec.Deprecated,
+		RequestBody: spec.RequestBody,
+		Responses:   spec.Responses,
+	}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		op.Parameters = append(op.Parameters, pathParameter(match[1]))
+	}
+	op.Parameters = append(op.Parameters, spec.Parameters...)
+
+	switch method {
+	case "GET":
+		item.Get = op
+	case "PUT":
+		item.Put = op
+	case "POST":
+		item.Post = op
+	case "DELETE":
+		item.Delete = op
+	case "OPTIONS":
+		item.Options = op
+	case "HEAD":
+		item.Head = op
+	case "PATCH":
+		item.Patch = op
+	default:
+		return fmt.Errorf("route %q has unsupported method %s", pattern, method)
+	}
+
+	if op.RequestBody != nil {
+		for _, media := range op.RequestBody.Content {
+			d.registerSchemas(media.Schema)
+		}
+	}
+	for _, response := range op.Responses {
+		for _, media := range response.Content {
+			d.registerSchemas(media.Schema)
+		}
+	}
+	return nil
+}
+
+// registerSchemas adds the models referenced by a schema to components
+func (d *Document) registerSchemas(schema *Schema) {
+	if schema == nil {
+		return
+	}
+	if schema.model != nil {
+		if _, ok := d.Components.Schemas[schema.model.Name()]; !ok {
+			d.Components.Schemas[schema.model.Name()] = modelSchema(schema.model)
+		}
+	}
+	d.registerSchemas(schema.Items)
+	for _, property := range schema.Properties {
+		d.registerSchemas(property)
+	}
+}
+
+func pathParameter(name string) Parameter {
+	schema := &Schema{Type: "string"}
+	if name == "id" || strings.HasSuffix(name, "_id") {
+		schema = &Schema{Type: "integer"}
+	}
+	return Parameter{Name: name, In: "path", Required: true, Schema: schema}
+}
+
+// operationID turns "GET /students/{id}" into get_students_id
+func operationID(method, path string) string {
+	id := strings.ToLower(method) + strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_", ".", "_").Replace(path)
+	return strings.TrimSuffix(id, "_")
+}
+
+// Ref references the schema of a model struct, the model is added to components.schemas
+func Ref(model interface{}) *Schema {
+	modelType := reflect.TypeOf(model)
+	return &Schema{Ref: "#/components/schemas/" + modelType.Name(), model: modelType}
+}
+
+func ArrayOf(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+func Object(properties map[string]*Schema) *Schema {
+	return &Schema{Type: "object", Properties: properties}
+}
+
+func String() *Schema {
+	return &Schema{Type: "string"}
+}
+
+func Integer() *Schema {
+	return &Schema{Type: "integer"}
+}
+
+// JSON is the content of a json request or response body
+func JSON(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
+
+// QueryParam documents an optional query parameter
+func QueryParam(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+// HeaderParam documents an optional request header
+func HeaderParam(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "header", Description: description, Schema: schema}
+}
+
+var nullStringType = reflect.TypeOf(sql.NullString{})
+
+// modelSchema builds an object schema from the json tags of a model struct
+func modelSchema(modelType reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		switch {
+		case field.Type == nullStringType:
+			schema.Properties[name] = &Schema{Type: []string{"string", "null"}}
+		case field.Type.Kind() == reflect.String:
+			schema.Properties[name] = &Schema{Type: "string"}
+		case field.Type.Kind() == reflect.Bool:
+			schema.Properties[name] = &Schema{Type: "boolean"}
+		case field.Type.Kind() >= reflect.Int && field.Type.Kind() <= reflect.Uint64:
+			schema.Properties[name] = &Schema{Type: "integer"}
+		case field.Type.Kind() == reflect.Float32 || field.Type.Kind() == reflect.Float64:
+			schema.Properties[name] = &Schema{Type: "number"}
+		default:
+			schema.Properties[name] = &Schema{}
+		}
+		if name == "email" {
+			schema.Properties[name].Format = "email"
+		}
+	}
+	return schema
+}
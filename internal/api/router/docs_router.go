@@ -0,0 +1,12 @@
+package router
+
+import (
+	"school-management/internal/api/openapi"
+	"school-management/pkg/routing"
+)
+
+func docsRouter(mux *routing.Group) {
+	mux.HandleFunc("GET /openapi.json", openapi.JSONHandler(OpenAPIDocument)).Named("openapi").Set(cacheControlKey, docsCacheControl)
+	mux.Handle("GET /docs", openapi.DocsHandler()).Named("docs").Set(cacheControlKey, docsCacheControl)
+	mux.Handle("GET /docs/{file...}", openapi.DocsHandler()).Named("docs.file").Set(cacheControlKey, docsCacheControl)
+}
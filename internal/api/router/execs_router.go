@@ -1,26 +1,28 @@
 package router
 
 import (
-	"net/http"
 	"school-management/internal/api/handlers"
+	"school-management/internal/models"
+	"school-management/pkg/routing"
 )
 
-func execsRouter() *http.ServeMux {
-	mux := http.NewServeMux()
+// execsRouter registers on its own group, middlewares meant only for execs routes (eg. auth) go into its Use
+func execsRouter(mux *routing.Group) {
+	execs := mux.Group("")
 
-	mux.HandleFunc("GET /execs", handlers.GetExecsHandler)
-	mux.HandleFunc("POST /execs", handlers.AddExecsHandler)
-	mux.HandleFunc("PATCH /execs", handlers.PatchExecsHandler)
+	execs.HandleFunc("GET /execs", handlers.GetExecsHandler).Named("execs.list").Set(queryParamsKey, listQueryParams(models.Exec{})).Set(cacheControlKey, privateRevalidate)
+	execs.HandleFunc("POST /execs", handlers.AddExecsHandler).Named("execs.create").Set(queryParamsKey, bulkQueryParams)
+	execs.HandleFunc("PATCH /execs", handlers.PatchExecsHandler).Named("execs.patch")
 
-	mux.HandleFunc("GET /execs/{id}", handlers.GetOneExecHandler)
-	mux.HandleFunc("PATCH /execs/{id}", handlers.PatchOneExecHandler)
-	// mux.HandleFunc("POST /execs/{id}/updatepassword", handlers.AddExecsHandler)
-	mux.HandleFunc("DELETE /execs/{id}", handlers.DeleteOneExecHandler)
+	execs.HandleFunc("GET /execs/{id}", handlers.GetOneExecHandler).Named("execs.show").Set(cacheControlKey, privateRevalidate)
+	execs.HandleFunc("PATCH /execs/{id}", handlers.PatchOneExecHandler).Named("execs.patchOne")
+	// execs.HandleFunc("POST /execs/{id}/updatepassword", handlers.AddExecsHandler)
+	execs.HandleFunc("DELETE /execs/{id}", handlers.DeleteOneExecHandler).Named("execs.deleteOne")
 
-	mux.HandleFunc("POST /execs/login", handlers.ExecsLoginHandler)
-	// mux.HandleFunc("POST /execs/logout", handlers.ExecsLogoutHandler)
+	// login has to stay reachable without being logged in, so it is registered outside of the execs group.
+	// a retried login just logs in again, a stored response would keep the token around
+	mux.HandleFunc("POST /execs/login", handlers.ExecsLoginHandler).Named("execs.login").Set(idempotentKey, false)
+	// execs.HandleFunc("POST /execs/logout", handlers.ExecsLogoutHandler)
 	// mux.HandleFunc("POST /execs/forgotpassword", handlers.ExecsForgotPasswordHandler)
 	// mux.HandleFunc("POST /execs/resetpassword/reset/{resetcode}", handlers.ExecsResetPasswordHandler)
-
-	return mux
 }
@@ -0,0 +1,13 @@
+package router
+
+import (
+	"school-management/internal/api/handlers"
+	"school-management/pkg/routing"
+)
+
+func healthRouter(mux *routing.Group) {
+	mux.HandleFunc("GET /healthz", handlers.HealthzHandler).Named("healthz")
+	mux.HandleFunc("GET /readyz", handlers.ReadyzHandler).Named("readyz")
+	mux.HandleFunc("GET /health/details", handlers.HealthDetailsHandler).Named("health.details")
+	mux.HandleFunc("GET /metrics", handlers.MetricsHandler).Named("metrics")
+}
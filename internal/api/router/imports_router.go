@@ -0,0 +1,10 @@
+package router
+
+import (
+	"school-management/internal/api/handlers"
+	"school-management/pkg/routing"
+)
+
+func importsRouter(mux *routing.Group) {
+	mux.HandleFunc("GET /imports/{id}", handlers.GetImportJobHandler).Named("imports.show").Set(cacheControlKey, privateRevalidate)
+}
@@ -0,0 +1,420 @@
+package router
+
+import (
+	"fmt"
+	"maps"
+	"school-management/internal/api/openapi"
+	"school-management/internal/models"
+	"school-management/pkg/utils"
+	"slices"
+	"strings"
+)
+
+// routeSpecs documents every registered route, keyed by its ServeMux pattern.
+// Adding a route without an entry here makes OpenAPIDocument (and the router tests) fail.
+var routeSpecs = map[string]openapi.RouteSpec{
+	// students
+	"GET /students":         listSpec("students", "List students", models.Student{}),
+	"POST /students":        createSpec("students", "Add students", models.Student{}),
+	"PATCH /students":       patchManySpec("students", "Patch multiple students"),
+	"DELETE /students":      deleteManySpec("students", "Delete multiple students"),
+	"POST /students/import": importSpec("students", "Import students from a csv or xlsx file"),
+	"GET /students/{id}":    getOneSpec("students", "Get a student", models.Student{}),
+	"PUT /students/{id}":    updateSpec("students", "Replace a student", models.Student{}),
+	"PATCH /students/{id}":  patchOneSpec("students", "Patch a student", models.Student{}),
+	"DELETE /students/{id}": deleteOneSpec("students", "Delete a student"),
+
+	// teachers
+	"GET /teachers":         listSpec("teachers", "List teachers", models.Teacher{}),
+	"POST /teachers":        createSpec("teachers", "Add teachers", models.Teacher{}),
+	"PATCH /teachers":       patchManySpec("teachers", "Patch multiple teachers"),
+	"DELETE /teachers":      deleteManySpec("teachers", "Delete multiple teachers"),
+	"POST /teachers/import": importSpec("teachers", "Import teachers from a csv or xlsx file"),
+	"GET /teachers/{id}":    getOneSpec("teachers", "Get a teacher", models.Teacher{}),
+	"PUT /teachers/{id}":    updateSpec("teachers", "Replace a teacher", models.Teacher{}),
+	"PATCH /teachers/{id}":  patchOneSpec("teachers", "Patch a teacher", models.Teacher{}),
+	"DELETE /teachers/{id}": deleteOneSpec("teachers", "Delete a teacher"),
+	"GET /teachers/{id}/students": {
+		Summary:   "List the students of a teacher's class",
+		Tags:      []string{"teachers"},
+		Responses: withErrors(okResponse("Students of the teacher", listEnvelope(models.Student{}))),
+	},
+	"GET /teachers/{id}/studentcount": {
+		Summary: "Count the students of a teacher's class",
+		Tags:    []string{"teachers"},
+		Responses: withErrors(okResponse("Student count", openapi.Object(map[string]*openapi.Schema{
+			"status": openapi.String(),
+			"count":  openapi.Integer(),
+		}))),
+	},
+
+	// execs
+	"GET /execs":         listSpec("execs", "List execs", models.Exec{}),
+	"POST /execs":        createSpec("execs", "Add execs", models.Exec{}),
+	"PATCH /execs":       patchManySpec("execs", "Patch multiple execs"),
+	"GET /execs/{id}":    getOneSpec("execs", "Get an exec", models.Exec{}),
+	"PATCH /execs/{id}":  patchOneSpec("execs", "Patch an exec", models.Exec{}),
+	"DELETE /execs/{id}": deleteOneSpec("execs", "Delete an exec"),
+	"POST /execs/login": {
+		Summary: "Log in as an exec",
+		Tags:    []string{"execs"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(openapi.Object(map[string]*openapi.Schema{
+			"username": openapi.String(),
+			"password": openapi.String(),
+		}))},
+		Responses: withErrors(okResponse("Logged in, the token is also set as a cookie", openapi.Object(map[string]*openapi.Schema{
+			"token": openapi.String(),
+		}))),
+	},
+
+	// imports
+	"GET /imports/{id}": {
+		Summary:   "Get the progress of an import job",
+		Tags:      []string{"imports"},
+		Responses: withNotFound(withErrors(okResponse("Import job", openapi.Ref(models.ImportJob{})))),
+	},
+
+	// docs
+	"GET /openapi.json": {
+		Summary:   "This OpenAPI document",
+		Tags:      []string{"docs"},
+		Responses: map[string]openapi.Response{"200": {Description: "OpenAPI 3.1 document", Content: openapi.JSON(openapi.Object(nil))}},
+	},
+	"GET /docs": {
+		Summary:   "Human readable api documentation",
+		Tags:      []string{"docs"},
+		Responses: map[string]openapi.Response{"200": {Description: "Docs page", Content: map[string]openapi.MediaType{"text/html": {}}}},
+	},
+	"GET /docs/{file...}": {
+		Summary:   "Assets of the docs page",
+		Tags:      []string{"docs"},
+		Responses: map[string]openapi.Response{"200": {Description: "Static file"}, "404": {Description: "Not found"}},
+	},
+	"GET /healthz": {
+		Summary:   "Liveness probe, succeeds while the process runs",
+		Tags:      []string{"health"},
+		Responses: map[string]openapi.Response{"200": {Description: "Alive", Content: openapi.JSON(healthStatusSchema())}},
+	},
+	"GET /readyz": {
+		Summary:     "Readiness probe",
+		Description: "Fails while the server drains on shutdown, the database is unreachable or migrations are pending.",
+		Tags:        []string{"health"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Ready", Content: openapi.JSON(readinessSchema())},
+			"503": {Description: "Not ready or draining", Content: openapi.JSON(readinessSchema())},
+		},
+	},
+	"GET /health/details": {
+		Summary:     "Detailed health for admins",
+		Description: "Build version, uptime, database pool stats and the latency of every dependency check. Needs `Authorization: Bearer <health admin token>`.",
+		Tags:        []string{"health"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Health details", Content: openapi.JSON(openapi.Object(map[string]*openapi.Schema{
+				"status":        openapi.String(),
+				"version":       openapi.String(),
+				"commit":        openapi.String(),
+				"go_version":    openapi.String(),
+				"started_at":    {Type: "string", Format: "date-time"},
+				"uptime":        openapi.String(),
+				"draining":      {Type: "boolean"},
+				"goroutines":    openapi.Integer(),
+				"checks":        openapi.ArrayOf(openapi.Object(map[string]*openapi.Schema{"name": openapi.String(), "status": openapi.String(), "latency_ms": {Type: "number"}, "error": openapi.String()})),
+				"database_pool": openapi.Object(nil),
+			}))},
+			"401": errorResponse("Missing or wrong admin token"),
+			"403": errorResponse("Health details are disabled"),
+		},
+	},
+	"GET /metrics": {
+		Summary:     "Prometheus metrics",
+		Description: "Request counts and latencies by route pattern, method and status, in-flight requests, database pool stats, rate limiter rejections and login failures in the Prometheus text format.",
+		Tags:        []string{"health"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Metrics", Content: map[string]openapi.MediaType{"text/plain": {Schema: openapi.String()}}},
+		},
+	},
+}
+
+func healthStatusSchema() *openapi.Schema {
+	return openapi.Object(map[string]*openapi.Schema{"status": openapi.String()})
+}
+
+func readinessSchema() *openapi.Schema {
+	return openapi.Object(map[string]*openapi.Schema{
+		"status": openapi.String(),
+		"checks": openapi.ArrayOf(openapi.Object(map[string]*openapi.Schema{"name": openapi.String(), "status": openapi.String()})),
+	})
+}
+
+// OpenAPIDocument builds the OpenAPI document of all registered routes, it fails if a route has no spec
+func OpenAPIDocument() (*openapi.Document, error) {
+	doc := openapi.NewDocument("School Management API", "1.0.0")
+
+	var missing []string
+	for _, route := range routeTable {
+		spec, ok := routeSpecs[route.Pattern]
+		if !ok {
+			if !slices.Contains(missing, route.Pattern) {
+				missing = append(missing, route.Pattern)
+			}
+			continue
+		}
+		spec.Deprecated = route.Deprecated
+		if route.Idempotent {
+			spec = withIdempotencyKey(spec)
+		}
+		if route.CacheControl != "" {
+			spec = withConditionalGet(spec, route.CacheControl)
+		}
+		err := doc.AddRoute(route.Method+" "+route.Path, spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("routes without openapi spec: %s", strings.Join(missing, ", "))
+	}
+	return doc, nil
+}
+
+// errorResponse is a problem response written by utils.WriteProblem
+func errorResponse(description string) openapi.Response {
+	return openapi.Response{Description: description, Content: map[string]openapi.MediaType{"application/problem+json": {Schema: problemSchema()}}}
+}
+
+func okResponse(description string, schema *openapi.Schema) map[string]openapi.Response {
+	return map[string]openapi.Response{"200": {Description: description, Content: openapi.JSON(schema)}}
+}
+
+// withErrors adds the error responses every handler can return
+func withErrors(responses map[string]openapi.Response) map[string]openapi.Response {
+	responses["400"] = errorResponse("Invalid request")
+	responses["500"] = errorResponse("Internal server error")
+	return responses
+}
+
+// withNotFound documents the 404 of routes addressing rows by id
+func withNotFound(responses map[string]openapi.Response) map[string]openapi.Response {
+	responses["404"] = errorResponse("No row with this id")
+	return responses
+}
+
+// withIdempotencyKey documents the Idempotency-Key header handled by the idempotency middleware
+func withIdempotencyKey(spec openapi.RouteSpec) openapi.RouteSpec {
+	spec.Parameters = append(slices.Clone(spec.Parameters), openapi.HeaderParam("Idempotency-Key",
+		"Unique key of the request, retries with the same key get the first response replayed (marked by Idempotent-Replayed: true) instead of running again", openapi.String()))
+
+	conflict := openapi.Response{
+		Description: "The Idempotency-Key is used by a request still in flight (retry after Retry-After) or by a different request",
+		Content:     map[string]openapi.MediaType{},
+	}
+	if existing, ok := spec.Responses["409"]; ok {
+		conflict.Description = existing.Description + ", or the Idempotency-Key is used by a request still in flight or by a different request"
+		maps.Copy(conflict.Content, existing.Content)
+	}
+	conflict.Content["application/problem+json"] = openapi.MediaType{Schema: problemSchema()}
+
+	spec.Responses = maps.Clone(spec.Responses)
+	spec.Responses["409"] = conflict
+	return spec
+}
+
+// withConditionalGet documents the validators and 304 responses of the caching middleware
+func withConditionalGet(spec openapi.RouteSpec, cacheControl string) openapi.RouteSpec {
+	spec.Parameters = append(slices.Clone(spec.Parameters),
+		openapi.HeaderParam("If-None-Match", "ETag of the client's copy, answered with 304 while it is current", openapi.String()),
+		openapi.HeaderParam("If-Modified-Since", "Only used without If-None-Match on responses with a Last-Modified", openapi.String()),
+	)
+	spec.Responses = maps.Clone(spec.Responses)
+	spec.Responses["304"] = openapi.Response{Description: "The client's copy is current (Cache-Control: " + cacheControl + ")"}
+	return spec
+}
+
+// problemSchema is the RFC 9457 body written by utils.WriteProblem
+func problemSchema() *openapi.Schema {
+	return openapi.Object(map[string]*openapi.Schema{
+		"type":       openapi.String(),
+		"title":      openapi.String(),
+		"status":     openapi.Integer(),
+		"detail":     openapi.String(),
+		"instance":   openapi.String(),
+		"request_id": openapi.String(),
+	})
+}
+
+func listEnvelope(model interface{}) *openapi.Schema {
+	return openapi.Object(map[string]*openapi.Schema{
+		"status": openapi.String(),
+		"count":  openapi.Integer(),
+		"data":   openapi.ArrayOf(openapi.Ref(model)),
+	})
+}
+
+// listParams documents the filter, sortby and format query params handled by utils.AddFilters/AddSorting and the exporters
+func listParams(model interface{}) []openapi.Parameter {
+	var params []openapi.Parameter
+	for _, param := range utils.FilterFieldsOf(model) {
+		params = append(params, openapi.QueryParam(param, "Filter by exact "+param, openapi.String()))
+	}
+
+	sortBy := openapi.QueryParam("sortby", "Sort by field:order, repeatable (sortby=class:asc&sortby=last_name:desc)", openapi.ArrayOf(&openapi.Schema{
+		Type:    "string",
+		Pattern: "^(" + strings.Join(utils.SortFields, "|") + "):(asc|desc)$",
+	}))
+	format := openapi.QueryParam("format", "Response format, overrides the Accept header", &openapi.Schema{Type: "string", Enum: []string{"json", "csv", "ndjson", "xlsx"}})
+	return append(params, sortBy, format)
+}
+
+func listSpec(tag, summary string, model interface{}) openapi.RouteSpec {
+	responses := withErrors(okResponse("List of "+tag, listEnvelope(model)))
+	export := responses["200"]
+	export.Content["text/csv"] = openapi.MediaType{Schema: openapi.String()}
+	export.Content["application/x-ndjson"] = openapi.MediaType{Schema: openapi.Ref(model)}
+	export.Content["application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"] = openapi.MediaType{}
+
+	return openapi.RouteSpec{
+		Summary:    summary,
+		Tags:       []string{tag},
+		Parameters: listParams(model),
+		Responses:  responses,
+	}
+}
+
+func createSpec(tag, summary string, model interface{}) openapi.RouteSpec {
+	responses := withErrors(map[string]openapi.Response{
+		"201": {Description: "Created " + tag, Content: openapi.JSON(listEnvelope(model))},
+		"207": {Description: "Per item results when atomic=false", Content: openapi.JSON(openapi.Object(map[string]*openapi.Schema{
+			"status":    openapi.String(),
+			"count":     openapi.Integer(),
+			"succeeded": openapi.Integer(),
+			"failed":    openapi.Integer(),
+			"results":   openapi.ArrayOf(openapi.Ref(models.BulkItemResult{})),
+		}))},
+	})
+	responses["409"] = errorResponse("A unique field is already used")
+
+	return openapi.RouteSpec{
+		Summary:     summary,
+		Tags:        []string{tag},
+		Parameters:  []openapi.Parameter{openapi.QueryParam("atomic", "All or nothing (default true), false inserts what it can and responds 207", &openapi.Schema{Type: "boolean"})},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(openapi.ArrayOf(openapi.Ref(model)))},
+		Responses:   responses,
+	}
+}
+
+func importSpec(tag, summary string) openapi.RouteSpec {
+	fileSchema := openapi.Object(map[string]*openapi.Schema{
+		"file":    {Type: "string", Format: "binary"},
+		"mapping": {Type: "string", Description: `json object of header to field, eg. {"Given Name":"first_name"}`},
+	})
+
+	responses := withErrors(map[string]openapi.Response{
+		"200": {Description: "Validation report of a dry run", Content: openapi.JSON(openapi.Object(map[string]*openapi.Schema{
+			"status":          openapi.String(),
+			"dry_run":         {Type: "boolean"},
+			"file_name":       openapi.String(),
+			"total_rows":      openapi.Integer(),
+			"valid_rows":      openapi.Integer(),
+			"invalid_rows":    openapi.Integer(),
+			"ignored_columns": openapi.ArrayOf(openapi.String()),
+			"errors":          openapi.ArrayOf(openapi.Ref(models.ImportRowError{})),
+		}))},
+		"202": {Description: "Import job started, poll the Location header", Content: openapi.JSON(openapi.Ref(models.ImportJob{}))},
+	})
+
+	return openapi.RouteSpec{
+		Summary: summary,
+		Tags:    []string{tag},
+		Parameters: []openapi.Parameter{
+			openapi.QueryParam("dry_run", "Only validate the file and report row errors", &openapi.Schema{Type: "boolean"}),
+			openapi.QueryParam("mapping", `json object of header to field, eg. {"Given Name":"first_name"}`, openapi.String()),
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"multipart/form-data": {Schema: fileSchema},
+			"text/csv":            {Schema: openapi.String()},
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": {},
+		}},
+		Responses: responses,
+	}
+}
+
+func getOneSpec(tag, summary string, model interface{}) openapi.RouteSpec {
+	return openapi.RouteSpec{
+		Summary:   summary,
+		Tags:      []string{tag},
+		Responses: withNotFound(withErrors(okResponse(summary, openapi.Ref(model)))),
+	}
+}
+
+func updateSpec(tag, summary string, model interface{}) openapi.RouteSpec {
+	responses := withNotFound(withErrors(okResponse("Updated", openapi.Ref(model))))
+	responses["409"] = errorResponse("A unique field is already used")
+
+	return openapi.RouteSpec{
+		Summary:     summary,
+		Tags:        []string{tag},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(openapi.Ref(model))},
+		Responses:   responses,
+	}
+}
+
+func patchOneSpec(tag, summary string, model interface{}) openapi.RouteSpec {
+	responses := withNotFound(withErrors(okResponse("Patched", openapi.Ref(model))))
+	responses["409"] = errorResponse("A unique field is already used, a test operation failed or a path doesn't exist")
+	responses["415"] = errorResponse("Unsupported Content-Type, the supported ones are listed in Accept-Patch")
+	responses["422"] = errorResponse("The patched " + strings.TrimSuffix(tag, "s") + " is invalid (read only or unknown field, wrong type, blank required field)")
+
+	jsonPatch := openapi.ArrayOf(openapi.Object(map[string]*openapi.Schema{
+		"op":    {Type: "string", Enum: []string{"add", "remove", "replace", "move", "copy", "test"}},
+		"path":  {Type: "string", Description: "json pointer, eg. /first_name"},
+		"from":  {Type: "string", Description: "json pointer of move and copy"},
+		"value": {Description: "value of add, replace and test"},
+	}))
+	return openapi.RouteSpec{
+		Summary:     summary,
+		Description: "Changes are applied atomically. A merge patch sets the given fields and clears the null ones, a json patch runs its operations in order and fails as a whole.",
+		Tags:        []string{tag},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"application/merge-patch+json": {Schema: openapi.Object(nil)},
+			"application/json":             {Schema: openapi.Object(nil)},
+			"application/json-patch+json":  {Schema: jsonPatch},
+		}},
+		Responses: responses,
+	}
+}
+
+func patchManySpec(tag, summary string) openapi.RouteSpec {
+	responses := withNotFound(withErrors(map[string]openapi.Response{"204": {Description: "Patched"}}))
+	responses["409"] = errorResponse("A unique field is already used")
+
+	return openapi.RouteSpec{
+		Summary:     summary,
+		Description: "Every item needs an id, all updates are applied in one transaction",
+		Tags:        []string{tag},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(openapi.ArrayOf(openapi.Object(map[string]*openapi.Schema{"id": openapi.String()})))},
+		Responses:   responses,
+	}
+}
+
+func deleteOneSpec(tag, summary string) openapi.RouteSpec {
+	return openapi.RouteSpec{
+		Summary: summary,
+		Tags:    []string{tag},
+		Responses: withNotFound(withErrors(okResponse("Deleted", openapi.Object(map[string]*openapi.Schema{
+			"status": openapi.String(),
+			"id":     openapi.Integer(),
+		})))),
+	}
+}
+
+func deleteManySpec(tag, summary string) openapi.RouteSpec {
+	return openapi.RouteSpec{
+		Summary:     summary,
+		Tags:        []string{tag},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(openapi.ArrayOf(openapi.Integer()))},
+		Responses: withNotFound(withErrors(okResponse("Deleted", openapi.Object(map[string]*openapi.Schema{
+			"status":      openapi.String(),
+			"deleted_ids": openapi.ArrayOf(openapi.Integer()),
+		})))),
+	}
+}
@@ -0,0 +1,185 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"school-management/internal/api/handlers"
+	"school-management/pkg/routing"
+	"school-management/pkg/utils"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// routeEntry is one row of the routing table
+type routeEntry struct {
+	Version    string
+	Name       string
+	Method     string
+	Path       string // full path including the version prefix
+	Pattern    string // pattern as registered on the version group, key of routeSpecs
+	Handler    string
+	Deprecated bool
+	Sunset     time.Time
+	// QueryParams the route accepts, nil if it didn't declare them
+	QueryParams []string
+	// BodyLimit in bytes, 0 if the route uses the default limit
+	BodyLimit int64
+	// Idempotent routes honor the Idempotency-Key header
+	Idempotent bool
+	// CacheControl policy of a GET route, empty if it isn't cacheable
+	CacheControl string
+}
+
+// routeTable records every route registered by MainRouter in registration order,
+// it is used to print the routing table and to generate the OpenAPI document
+var routeTable []routeEntry
+
+// versionKey is the routing meta key holding the apiVersion of a route
+const versionKey = "version"
+
+// queryParamsKey is the routing meta key holding the query params a route accepts, the hpp middleware drops (or rejects) the others
+const queryParamsKey = "query_params"
+
+var (
+	bulkQueryParams   = []string{"atomic"}
+	importQueryParams = []string{"dry_run", "mapping"}
+)
+
+// bodyLimitKey is the routing meta key holding the body limit of a route in bytes, it overrides server.max_body_size
+const bodyLimitKey = "body_limit"
+
+var importBodyLimit int64 = handlers.MaxImportFileSize
+
+// idempotentKey is the routing meta key turning the Idempotency-Key header on or off for a route,
+// POST and PATCH routes honor it unless they set it to false
+const idempotentKey = "idempotent"
+
+// cacheControlKey is the routing meta key holding the Cache-Control policy of a GET route, the caching middleware
+// adds an ETag to its responses and answers 304 to clients whose copy is current. Routes without one aren't cached.
+const cacheControlKey = "cache_control"
+
+const (
+	// privateRevalidate lets the client keep its copy of the data but revalidate it before every use, which is cheap with the ETag
+	privateRevalidate = "private, no-cache"
+	// docsCacheControl lets shared caches serve the docs for a while, they only change with a deploy
+	docsCacheControl = "public, max-age=300"
+)
+
+// listQueryParams are the filters of the entity plus sorting and the export format
+func listQueryParams(model interface{}) []string {
+	return append(utils.FilterFieldsOf(model), "sortby", "format")
+}
+
+func buildRouteTable(routes []*routing.Route) []routeEntry {
+	table := make([]routeEntry, 0, len(routes))
+	for _, route := range routes {
+		version, _ := route.Meta[versionKey].(apiVersion)
+		queryParams, _ := route.Meta[queryParamsKey].([]string)
+		bodyLimit, _ := route.Meta[bodyLimitKey].(int64)
+		cacheControl, _ := route.Meta[cacheControlKey].(string)
+		idempotent, ok := route.Meta[idempotentKey].(bool)
+		if !ok {
+			idempotent = route.Method == http.MethodPost || route.Method == http.MethodPatch
+		}
+		table = append(table, routeEntry{
+			Version:      version.Name,
+			Name:         route.Name,
+			Method:       route.Method,
+			Path:         route.Path,
+			Pattern:      route.LocalPattern,
+			Handler:      handlerName(route.Handler),
+			Deprecated:   version.Deprecated,
+			Sunset:       version.Sunset,
+			QueryParams:  queryParams,
+			BodyLimit:    bodyLimit,
+			Idempotent:   idempotent,
+			CacheControl: cacheControl,
+		})
+	}
+	return table
+}
+
+func handlerName(handler http.Handler) string {
+	if fn, ok := handler.(http.HandlerFunc); ok {
+		name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		return name[strings.LastIndex(name, "/")+1:]
+	}
+	return fmt.Sprintf("%T", handler)
+}
+
+// QueryParamWhitelists maps the ServeMux pattern of every route registered by MainRouter
+// that declared its query params to them, it is the per-route whitelist of the hpp middleware
+func QueryParamWhitelists() map[string][]string {
+	whitelists := make(map[string][]string)
+	for _, route := range routeTable {
+		if route.QueryParams != nil {
+			whitelists[route.Method+" "+route.Path] = route.QueryParams
+		}
+	}
+	return whitelists
+}
+
+// BodyLimits maps the ServeMux pattern of every route registered by MainRouter with a body limit of its own to it
+func BodyLimits() map[string]int64 {
+	limits := make(map[string]int64)
+	for _, route := range routeTable {
+		if route.BodyLimit > 0 {
+			limits[route.Method+" "+route.Path] = route.BodyLimit
+		}
+	}
+	return limits
+}
+
+// CachePolicies maps the ServeMux pattern of every route registered by MainRouter with a Cache-Control policy to it
+func CachePolicies() map[string]string {
+	policies := make(map[string]string)
+	for _, route := range routeTable {
+		if route.CacheControl != "" {
+			policies[route.Method+" "+route.Path] = route.CacheControl
+		}
+	}
+	return policies
+}
+
+// IdempotentRoutes are the ServeMux patterns of the routes registered by MainRouter which honor the Idempotency-Key header
+func IdempotentRoutes() []string {
+	var patterns []string
+	for _, route := range routeTable {
+		if route.Idempotent {
+			patterns = append(patterns, route.Method+" "+route.Path)
+		}
+	}
+	return patterns
+}
+
+// RoutePatterns are the ServeMux patterns of every route registered by MainRouter, the metrics middleware labels requests by them
+func RoutePatterns() []string {
+	patterns := make([]string, 0, len(routeTable))
+	for _, route := range routeTable {
+		patterns = append(patterns, route.Method+" "+route.Path)
+	}
+	return patterns
+}
+
+// PrintRoutes writes the routing table of MainRouter for review
+func PrintRoutes(w io.Writer) {
+	MainRouter()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tNAME\tVERSION\tHANDLER\tDEPRECATED\tSUNSET")
+	for _, route := range routeTable {
+		deprecated, sunset := "", ""
+		if route.Deprecated {
+			deprecated = "yes"
+		}
+		if !route.Sunset.IsZero() {
+			sunset = route.Sunset.Format(time.DateOnly)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", route.Method, route.Path, route.Name, route.Version, route.Handler, deprecated, sunset)
+	}
+	tw.Flush()
+}
@@ -1,17 +1,68 @@
 package router
 
 import (
-	"net/http"
+	"school-management/internal/api/handlers"
+	mw "school-management/internal/api/middlewares"
+	"school-management/pkg/routing"
+	"school-management/pkg/utils"
+	"time"
 )
 
-func MainRouter() *http.ServeMux {
+// apiVersion is a mounted version of the api. Every version registers its own routers,
+// so a v2 router of an entity can run side by side with the v1 one (eg. Routers: studentsRouterV2, teachersRouter, ...)
+type apiVersion struct {
+	Name    string
+	Prefix  string
+	Routers []func(*routing.Group)
 
-	sRouter := studentsRouter()
-	tRouter := teachersRouter()
-	eRouter := execsRouter()
+	Deprecated   bool
+	DeprecatedAt time.Time
+	Sunset       time.Time
+	Successor    string // prefix of the version replacing this one
+}
+
+var entityRouters = []func(*routing.Group){studentsRouter, teachersRouter, execsRouter, importsRouter}
+
+var apiVersions = []apiVersion{
+	{Name: "v1", Prefix: "/api/v1", Routers: entityRouters},
+	// unversioned routes are kept for existing clients until they moved to /api/v1
+	{
+		Name:         "legacy",
+		Prefix:       "",
+		Routers:      entityRouters,
+		Deprecated:   true,
+		DeprecatedAt: time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+		Sunset:       time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC),
+		Successor:    "/api/v1",
+	},
+}
+
+func MainRouter() *routing.Router {
+	mux := routing.New()
+
+	for _, version := range apiVersions {
+		var middlewares []utils.Middleware
+		if version.Deprecated {
+			middlewares = append(middlewares, mw.Deprecation(mw.DeprecationOptions{
+				DeprecatedAt: version.DeprecatedAt,
+				Sunset:       version.Sunset,
+				Successor:    version.Successor,
+			}))
+		}
+
+		group := mux.Group(version.Prefix, middlewares...).Named(version.Name+".").Set(versionKey, version)
+		for _, register := range version.Routers {
+			register(group)
+		}
+	}
+
+	// docs are not versioned, they describe every version
+	docsRouter(mux.Group("").Set(versionKey, apiVersion{Name: "docs"}))
+	// probes for the orchestrator, unversioned as well
+	healthRouter(mux.Group("").Set(versionKey, apiVersion{Name: "health"}))
 
-	sRouter.Handle("/", eRouter)
-	tRouter.Handle("/", sRouter)
+	routeTable = buildRouteTable(mux.Routes())
+	handlers.RouteURL = mux.URL
 
-	return tRouter
+	return mux
 }
@@ -0,0 +1,357 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"school-management/internal/api/handlers"
+	mw "school-management/internal/api/middlewares"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEveryRouteHasOpenAPISpec(t *testing.T) {
+	MainRouter()
+
+	registered := make(map[string]bool)
+	for _, route := range routeTable {
+		registered[route.Pattern] = true
+		if _, ok := routeSpecs[route.Pattern]; !ok {
+			t.Errorf("route %q is registered without openapi spec metadata, add it to routeSpecs", route.Pattern)
+		}
+	}
+
+	for pattern := range routeSpecs {
+		if !registered[pattern] {
+			t.Errorf("routeSpecs documents %q which is not registered", pattern)
+		}
+	}
+}
+
+func TestOpenAPIDocumentIsServed(t *testing.T) {
+	mux := MainRouter()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	var doc struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi = %q, want 3.1.0", doc.OpenAPI)
+	}
+	for _, path := range []string{"/api/v1/students", "/api/v1/teachers/{id}", "/api/v1/execs/login", "/students"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("path %s missing from document", path)
+		}
+	}
+	for _, schema := range []string{"Student", "Teacher", "Exec"} {
+		if _, ok := doc.Components.Schemas[schema]; !ok {
+			t.Errorf("schema %s missing from components", schema)
+		}
+	}
+}
+
+func TestVersionedAndLegacyRoutes(t *testing.T) {
+	mux := MainRouter()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/students/abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /api/v1/students/abc status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec.Header().Get("Deprecation") != "" {
+		t.Errorf("v1 route has Deprecation header %q", rec.Header().Get("Deprecation"))
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/students/abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /students/abc status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec.Header().Get("Deprecation") == "" || rec.Header().Get("Sunset") == "" {
+		t.Errorf("legacy route is missing Deprecation/Sunset headers: %v", rec.Header())
+	}
+	if link := rec.Header().Get("Link"); link != `</api/v1/students/abc>; rel="successor-version"` {
+		t.Errorf("Link = %q", link)
+	}
+}
+
+func TestNotFoundAndMethodNotAllowedAreJSON(t *testing.T) {
+	mux := MainRouter()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/nothing", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("GET /api/v1/nothing = %d %q, want 404 json", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/execs", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("PUT /api/v1/execs = %d %q, want 405 json", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD, POST, PATCH" {
+		t.Errorf("Allow = %q", allow)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/api/v1/students/7", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS /api/v1/students/7 status = %d, want 204", rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD, PUT, PATCH, DELETE, OPTIONS" {
+		t.Errorf("Allow = %q", allow)
+	}
+}
+
+// handler errors are problem responses carrying the request id, like the ones of the middlewares
+func TestHandlerErrorsAreProblems(t *testing.T) {
+	h := mw.RequestID(MainRouter())
+
+	tests := []struct {
+		method, target, contentType string
+		expected                    int
+	}{
+		{http.MethodGet, "/api/v1/students/abc", "", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/teachers?atomic=maybe", "application/json", http.StatusBadRequest},
+		{http.MethodPatch, "/api/v1/students/1", "application/json-patch+json", http.StatusBadRequest},
+		{http.MethodPatch, "/api/v1/execs/1", "text/plain", http.StatusUnsupportedMediaType},
+		{http.MethodGet, "/api/v1/imports/abc", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`[{"op":"add"}]`))
+		r.Header.Set("X-Request-ID", "problem-test")
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		var problem struct {
+			Status    int    `json:"status"`
+			Detail    string `json:"detail"`
+			RequestID string `json:"request_id"`
+		}
+		if w.Code != tt.expected || w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s %s: expected a %d problem, got %d %q", tt.method, tt.target, tt.expected, w.Code, w.Header().Get("Content-Type"))
+			continue
+		}
+		if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+		if problem.Status != tt.expected || problem.Detail == "" || problem.RequestID != "problem-test" {
+			t.Errorf("%s %s: unexpected problem %+v", tt.method, tt.target, problem)
+		}
+	}
+}
+
+func TestNamedRouteURLs(t *testing.T) {
+	mux := MainRouter()
+
+	url, err := mux.URL("v1.teachers.students", "id", "12")
+	if err != nil || url != "/api/v1/teachers/12/students" {
+		t.Errorf("URL(v1.teachers.students) = %q, %v", url, err)
+	}
+	url, err = mux.URL("legacy.students.show", "id", "3")
+	if err != nil || url != "/students/3" {
+		t.Errorf("URL(legacy.students.show) = %q, %v", url, err)
+	}
+	if _, err := mux.URL("v1.students.show"); err == nil {
+		t.Error("URL without the id param should fail")
+	}
+}
+
+func TestQueryParamWhitelists(t *testing.T) {
+	MainRouter()
+	whitelists := QueryParamWhitelists()
+
+	students := whitelists["GET /api/v1/students"]
+	if !slices.Contains(students, "class") || !slices.Contains(students, "sortby") || slices.Contains(students, "subject") {
+		t.Errorf("GET /api/v1/students whitelist = %v", students)
+	}
+	if teachers := whitelists["GET /teachers"]; !slices.Contains(teachers, "subject") {
+		t.Errorf("GET /teachers whitelist = %v, want the subject filter", teachers)
+	}
+	if bulk := whitelists["POST /api/v1/execs"]; !slices.Equal(bulk, []string{"atomic"}) {
+		t.Errorf("POST /api/v1/execs whitelist = %v", bulk)
+	}
+	if _, ok := whitelists["GET /api/v1/students/{id}"]; ok {
+		t.Error("GET /api/v1/students/{id} declares no query params and should use the global whitelist")
+	}
+}
+
+// the route whitelists have to keep every param the handlers read, strict hpp would refuse the request otherwise
+func TestHppKeepsDeclaredQueryParams(t *testing.T) {
+	MainRouter()
+	hpp, err := mw.NewHpp(mw.HPPOptions{CheckQuery: true, Whitelist: []string{"sortBy"}, Routes: QueryParamWhitelists(), Duplicates: mw.DuplicatesFirst, Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var query url.Values
+	h := hpp(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+	}))
+
+	tests := []struct {
+		target   string
+		expected int
+		kept     []string
+	}{
+		{`/api/v1/students/import?dry_run=true&mapping={"Given+Name":"first_name"}`, http.StatusOK, []string{"dry_run", "mapping"}},
+		{`/teachers/import?mapping={}`, http.StatusOK, []string{"mapping"}},
+		{`/api/v1/students?dry_run=true`, http.StatusBadRequest, nil},
+		{`/api/v1/students/import?atomic=false`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		query = nil
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.target, nil))
+		if w.Code != tt.expected {
+			t.Errorf("POST %s: expected %d, got %d %s", tt.target, tt.expected, w.Code, w.Body)
+			continue
+		}
+		for _, param := range tt.kept {
+			if !query.Has(param) {
+				t.Errorf("POST %s: %s was dropped", tt.target, param)
+			}
+		}
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	MainRouter()
+	limits := BodyLimits()
+
+	for _, pattern := range []string{"POST /api/v1/students/import", "POST /teachers/import"} {
+		if limits[pattern] != handlers.MaxImportFileSize {
+			t.Errorf("%s body limit = %d, want %d", pattern, limits[pattern], handlers.MaxImportFileSize)
+		}
+	}
+	if _, ok := limits["POST /api/v1/students"]; ok {
+		t.Error("POST /api/v1/students declares no body limit and should use server.max_body_size")
+	}
+}
+
+// a body over the limit is answered with 413 whichever reader hits the limit first
+func TestBodyLimitResponses(t *testing.T) {
+	const limit = 64
+	tooLarge := `[{"first_name":"` + strings.Repeat("a", limit) + `"}]`
+	// hides the length, so the body is only found too large while it is read
+	chunked := func(body string) io.Reader { return io.MultiReader(strings.NewReader(body)) }
+
+	tests := []struct {
+		name        string
+		middlewares []string
+		target      string
+		body        io.Reader
+		expected    int
+	}{
+		{"announced length", []string{"body_limit", "hpp"}, "/api/v1/students", strings.NewReader(tooLarge), http.StatusRequestEntityTooLarge},
+		{"read by the handler", []string{"body_limit"}, "/api/v1/students", chunked(tooLarge), http.StatusRequestEntityTooLarge},
+		{"read by hpp", []string{"body_limit", "hpp"}, "/api/v1/students", chunked(tooLarge), http.StatusRequestEntityTooLarge},
+		{"below the limit", []string{"body_limit", "hpp"}, "/api/v1/students", chunked(`[{"first_name":`), http.StatusBadRequest},
+		{"route limit", []string{"body_limit", "hpp"}, "/api/v1/students/import", chunked(tooLarge), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := mw.BuildPipeline(MainRouter(), mw.PipelineConfig{
+				Middlewares: append([]string{"request_id"}, tt.middlewares...),
+				BodyLimit:   mw.BodyLimitOptions{Default: limit, Routes: BodyLimits()},
+				Hpp:         mw.HPPOptions{CheckJSON: true, Duplicates: mw.DuplicatesFirst},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPost, tt.target, tt.body)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.expected {
+				t.Fatalf("expected %d, got %d %s", tt.expected, w.Code, w.Body)
+			}
+			if tt.expected == http.StatusRequestEntityTooLarge && w.Header().Get("Content-Type") != "application/problem+json" {
+				t.Errorf("expected a problem response, got %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// a retry with the same Idempotency-Key is replayed on the routes declaring it and runs the handler again elsewhere
+func TestIdempotentRoutes(t *testing.T) {
+	MainRouter()
+	idempotency, err := mw.NewIdempotency(mw.IdempotencyOptions{Routes: IdempotentRoutes(), TTL: time.Minute, LockTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	h := idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	tests := []struct {
+		method, target string
+		replayed       bool
+	}{
+		{http.MethodPost, "/api/v1/students", true},
+		{http.MethodPatch, "/api/v1/teachers/1", true},
+		{http.MethodPost, "/execs", true},
+		{http.MethodPost, "/api/v1/execs/login", false},
+		{http.MethodPut, "/api/v1/students/1", false},
+	}
+	for _, tt := range tests {
+		calls = 0
+		var replayed string
+		for range 2 {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{}`))
+			r.Header.Set("Idempotency-Key", "key-"+tt.method+tt.target)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			replayed = w.Header().Get("Idempotent-Replayed")
+		}
+		if tt.replayed && (calls != 1 || replayed != "true") {
+			t.Errorf("%s %s: expected the retry to be replayed, the handler ran %d times", tt.method, tt.target, calls)
+		}
+		if !tt.replayed && (calls != 2 || replayed != "") {
+			t.Errorf("%s %s: expected the Idempotency-Key to be ignored, the handler ran %d times", tt.method, tt.target, calls)
+		}
+	}
+}
+
+func TestCachePolicies(t *testing.T) {
+	MainRouter()
+	policies := CachePolicies()
+
+	expected := map[string]string{
+		"GET /api/v1/students":      privateRevalidate,
+		"GET /api/v1/teachers/{id}": privateRevalidate,
+		"GET /openapi.json":         docsCacheControl,
+	}
+	for pattern, policy := range expected {
+		if policies[pattern] != policy {
+			t.Errorf("%s: expected Cache-Control %q, got %q", pattern, policy, policies[pattern])
+		}
+	}
+	for _, pattern := range []string{"POST /api/v1/students", "PATCH /api/v1/execs/{id}"} {
+		if policy, ok := policies[pattern]; ok {
+			t.Errorf("%s should not be cached, got %q", pattern, policy)
+		}
+	}
+}
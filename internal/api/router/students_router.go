@@ -1,21 +1,20 @@
 package router
 
 import (
-	"net/http"
 	"school-management/internal/api/handlers"
+	"school-management/internal/models"
+	"school-management/pkg/routing"
 )
 
-func studentsRouter() *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /students", handlers.GetStudentsHandler)
-	mux.HandleFunc("POST /students", handlers.AddStudentsHandler)
-	mux.HandleFunc("PATCH /students", handlers.PatchStudentsHandler)
-	mux.HandleFunc("DELETE /students", handlers.DeleteStudentsHandler)
+func studentsRouter(mux *routing.Group) {
+	mux.HandleFunc("GET /students", handlers.GetStudentsHandler).Named("students.list").Set(queryParamsKey, listQueryParams(models.Student{})).Set(cacheControlKey, privateRevalidate)
+	mux.HandleFunc("POST /students", handlers.AddStudentsHandler).Named("students.create").Set(queryParamsKey, bulkQueryParams)
+	mux.HandleFunc("PATCH /students", handlers.PatchStudentsHandler).Named("students.patch")
+	mux.HandleFunc("DELETE /students", handlers.DeleteStudentsHandler).Named("students.delete")
+	mux.HandleFunc("POST /students/import", handlers.ImportStudentsHandler).Named("students.import").Set(queryParamsKey, importQueryParams).Set(bodyLimitKey, importBodyLimit)
 
-	mux.HandleFunc("GET /students/{id}", handlers.GetOneStudentHandler)
-	mux.HandleFunc("PUT /students/{id}", handlers.UpdateStudentsHandler)
-	mux.HandleFunc("PATCH /students/{id}", handlers.PatchOneStudentHandler)
-	mux.HandleFunc("DELETE /students/{id}", handlers.DeleteStudentHandler)
-
-	return mux
+	mux.HandleFunc("GET /students/{id}", handlers.GetOneStudentHandler).Named("students.show").Set(cacheControlKey, privateRevalidate)
+	mux.HandleFunc("PUT /students/{id}", handlers.UpdateStudentsHandler).Named("students.update")
+	mux.HandleFunc("PATCH /students/{id}", handlers.PatchOneStudentHandler).Named("students.patchOne")
+	mux.HandleFunc("DELETE /students/{id}", handlers.DeleteStudentHandler).Named("students.deleteOne")
 }
@@ -1,25 +1,24 @@
 package router
 
 import (
-	"net/http"
 	"school-management/internal/api/handlers"
+	"school-management/internal/models"
+	"school-management/pkg/routing"
 )
 
-func teachersRouter() *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /teachers", handlers.GetTeachersHandler)
-	mux.HandleFunc("POST /teachers", handlers.AddTeachersHandler)
-	mux.HandleFunc("PATCH /teachers", handlers.PatchTeachersHandler)
-	mux.HandleFunc("DELETE /teachers", handlers.DeleteTeachersHandler)
+func teachersRouter(mux *routing.Group) {
+	mux.HandleFunc("GET /teachers", handlers.GetTeachersHandler).Named("teachers.list").Set(queryParamsKey, listQueryParams(models.Teacher{})).Set(cacheControlKey, privateRevalidate)
+	mux.HandleFunc("POST /teachers", handlers.AddTeachersHandler).Named("teachers.create").Set(queryParamsKey, bulkQueryParams)
+	mux.HandleFunc("PATCH /teachers", handlers.PatchTeachersHandler).Named("teachers.patch")
+	mux.HandleFunc("DELETE /teachers", handlers.DeleteTeachersHandler).Named("teachers.delete")
+	mux.HandleFunc("POST /teachers/import", handlers.ImportTeachersHandler).Named("teachers.import").Set(queryParamsKey, importQueryParams).Set(bodyLimitKey, importBodyLimit)
 
-	mux.HandleFunc("GET /teachers/{id}", handlers.GetOneTeacherHandler)
-	mux.HandleFunc("PUT /teachers/{id}", handlers.UpdateTeachersHandler)
-	mux.HandleFunc("PATCH /teachers/{id}", handlers.PatchOneTeacherHandler)
-	mux.HandleFunc("DELETE /teachers/{id}", handlers.DeleteTeacherHandler)
+	mux.HandleFunc("GET /teachers/{id}", handlers.GetOneTeacherHandler).Named("teachers.show").Set(cacheControlKey, privateRevalidate)
+	mux.HandleFunc("PUT /teachers/{id}", handlers.UpdateTeachersHandler).Named("teachers.update")
+	mux.HandleFunc("PATCH /teachers/{id}", handlers.PatchOneTeacherHandler).Named("teachers.patchOne")
+	mux.HandleFunc("DELETE /teachers/{id}", handlers.DeleteTeacherHandler).Named("teachers.deleteOne")
 
 	// Related routes
-	mux.HandleFunc("GET /teachers/{id}/students", handlers.GetStudentsByTeacherId)
-	mux.HandleFunc("GET /teachers/{id}/studentcount", handlers.GetStudentCountById)
-
-	return mux
+	mux.HandleFunc("GET /teachers/{id}/students", handlers.GetStudentsByTeacherId).Named("teachers.students").Set(cacheControlKey, privateRevalidate)
+	mux.HandleFunc("GET /teachers/{id}/studentcount", handlers.GetStudentCountById).Named("teachers.studentCount").Set(cacheControlKey, privateRevalidate)
 }
@@ -0,0 +1,353 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// Config is the typed configuration of the api server. Every layer (defaults, config file, environment, flags)
+// overrides the one before it, fields are mapped by the yaml, env and flag struct tags.
+// Fields tagged secret:"true" are redacted when the config is printed.
+type Config struct {
+	Server      Server      `yaml:"server"`
+	TLS         TLS         `yaml:"tls"`
+	Database    Database    `yaml:"database"`
+	Auth        Auth        `yaml:"auth"`
+	Cors        Cors        `yaml:"cors"`
+	RateLimit   RateLimit   `yaml:"rate_limit"`
+	Logging     Logging     `yaml:"logging"`
+	Health      Health      `yaml:"health"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Idempotency Idempotency `yaml:"idempotency"`
+}
+
+type Server struct {
+	Addr string `yaml:"addr" env:"API_PORT" flag:"addr" usage:"address the server listens on, eg. :3000"`
+	// Middlewares in the order they see a request, the first one is the outermost
+	Middlewares []string    `yaml:"middlewares" env:"MIDDLEWARES" flag:"middlewares" usage:"comma separated global middlewares, outermost first"`
+	Hpp         HPP         `yaml:"hpp"`
+	Compression Compression `yaml:"compression"`
+	Caching     Caching     `yaml:"caching"`
+	// DrainDelay keeps serving after a shutdown signal while readiness already reports false, so load balancers can stop routing first
+	DrainDelay time.Duration `yaml:"drain_delay" env:"DRAIN_DELAY" flag:"drain-delay" usage:"time to keep serving after a shutdown signal before draining"`
+	// ShutdownTimeout bounds the time to drain in-flight requests and stop background workers
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"max time to drain requests on shutdown"`
+	// MaxBodySize in bytes applies to routes without a limit of their own (imports allow 32 MB), 0 disables it
+	MaxBodySize int `yaml:"max_body_size" env:"MAX_BODY_SIZE" flag:"max-body-size" usage:"request body limit in bytes"`
+	// BodyLimits override the limit of single routes, keyed by ServeMux pattern (eg. "POST /api/v1/students")
+	BodyLimits map[string]int `yaml:"body_limits"`
+	// timeouts of the http server, 0 disables one. WriteTimeout bounds whole responses including exports.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"IDLE_TIMEOUT"`
+}
+
+type HPP struct {
+	CheckQuery                  bool   `yaml:"check_query" env:"HPP_CHECK_QUERY"`
+	CheckBody                   bool   `yaml:"check_body" env:"HPP_CHECK_BODY"`
+	CheckBodyOnlyForContentType string `yaml:"check_body_only_for_content_type" env:"HPP_CHECK_BODY_CONTENT_TYPE"`
+	// CheckJSON looks for duplicate keys in json bodies
+	CheckJSON bool `yaml:"check_json" env:"HPP_CHECK_JSON"`
+	// Whitelist applies to routes which don't declare their query params, list routes accept their entity's filters
+	Whitelist []string `yaml:"whitelist" env:"HPP_WHITELIST"`
+	// Repeatable params may be sent more than once
+	Repeatable []string `yaml:"repeatable" env:"HPP_REPEATABLE"`
+	// Duplicates is first, last (the value kept of a repeated param or json key) or reject
+	Duplicates string `yaml:"duplicates" env:"HPP_DUPLICATES"`
+	// Strict answers 400 instead of dropping params
+	Strict bool `yaml:"strict" env:"HPP_STRICT" flag:"hpp-strict" usage:"reject requests with polluted params instead of cleaning them"`
+}
+
+type Compression struct {
+	// MinSize in bytes, smaller responses aren't worth compressing
+	MinSize int `yaml:"min_size" env:"COMPRESSION_MIN_SIZE"`
+	// ContentTypes are the compressible media types, "text/*" allows every text type
+	ContentTypes []string `yaml:"content_types" env:"COMPRESSION_CONTENT_TYPES"`
+}
+
+type Caching struct {
+	// Policies override the Cache-Control of single GET routes, keyed by ServeMux pattern (eg. "GET /api/v1/students": "private, max-age=60")
+	Policies map[string]string `yaml:"policies"`
+	// MaxBodySize in bytes is held back to compute the ETag, larger responses (eg. big exports) are sent without validators
+	MaxBodySize int `yaml:"max_body_size" env:"CACHING_MAX_BODY_SIZE"`
+}
+
+type TLS struct {
+	Enabled  bool   `yaml:"enabled" env:"TLS_ENABLED" flag:"tls" usage:"serve https"`
+	CertFile string `yaml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"path of the tls certificate"`
+	KeyFile  string `yaml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"path of the tls private key"`
+	// MinVersion is 1.2 or 1.3, tls 1.2 is limited to forward secret AEAD cipher suites
+	MinVersion string `yaml:"min_version" env:"TLS_MIN_VERSION"`
+	// ReloadInterval is how often the cert and key files are checked for changes
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL"`
+	// RedirectAddr starts a plain http listener redirecting to https, empty disables it
+	RedirectAddr string `yaml:"redirect_addr" env:"TLS_REDIRECT_ADDR" flag:"tls-redirect-addr" usage:"address of the http to https redirect listener"`
+	// ClientAuth is none, optional (verify a client certificate if one is sent) or require
+	ClientAuth   string `yaml:"client_auth" env:"TLS_CLIENT_AUTH"`
+	ClientCAFile string `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+}
+
+type Database struct {
+	DSN             string        `yaml:"dsn" env:"CONNECTION_STRING" secret:"true"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" flag:"db-max-open-conns" usage:"max open database connections (0 = unlimited)"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" flag:"db-max-idle-conns" usage:"max idle database connections"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+}
+
+type Auth struct {
+	// JWTSecret signs the HS256 tokens issued on login, at least 32 bytes
+	JWTSecret string        `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	TokenTTL  time.Duration `yaml:"token_ttl" env:"JWT_EXPIRES_IN"`
+	// APIKeys are the keys accepted in the X-API-Key header by client name, the api_key rate limit key only trusts these
+	APIKeys map[string]string `yaml:"api_keys" secret:"true"`
+}
+
+type Cors struct {
+	// AllowedOrigins are exact origins, "*" or subdomain patterns like "https://*.example.com"
+	AllowedOrigins   []string      `yaml:"allowed_origins" env:"CORS_ALLOWED_ORIGINS" flag:"cors-origins" usage:"comma separated allowed origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `yaml:"exposed_headers" env:"CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `yaml:"allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `yaml:"max_age" env:"CORS_MAX_AGE"`
+}
+
+type RateLimit struct {
+	RateLimitPolicy `yaml:",inline"`
+	// Routes have their own policy, keyed by ServeMux pattern (eg. "POST /api/v1/execs/login"), the defaults are merged in
+	Routes map[string]RateLimitPolicy `yaml:"routes"`
+	// TrustedProxies are the CIDRs (or ips) whose X-Forwarded-For header is believed
+	TrustedProxies []string      `yaml:"trusted_proxies" env:"RATE_LIMIT_TRUSTED_PROXIES"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout" env:"RATE_LIMIT_IDLE_TIMEOUT"`
+	// Store is memory (per replica) or sql (shared by all replicas in the rate_limits table)
+	Store string `yaml:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" usage:"memory or sql"`
+}
+
+type RateLimitPolicy struct {
+	// Name lets routes share their counts, policies of the same name need the same settings
+	Name      string        `yaml:"name"`
+	Algorithm string        `yaml:"algorithm" env:"RATE_LIMIT_ALGORITHM" usage:"token_bucket or sliding_window"`
+	Limit     int           `yaml:"limit" env:"RATE_LIMIT" flag:"rate-limit" usage:"requests per window and client"`
+	Window    time.Duration `yaml:"window" env:"RATE_LIMIT_WINDOW" flag:"rate-limit-window" usage:"rate limit window"`
+	Key       string        `yaml:"key" env:"RATE_LIMIT_KEY"`
+}
+
+type Idempotency struct {
+	// TTL keeps a response for retries with the same Idempotency-Key that long
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" flag:"idempotency-ttl" usage:"how long responses are kept for retries"`
+	// LockTimeout frees the key of a request which never completed (eg. its replica crashed), it should exceed server.write_timeout
+	LockTimeout time.Duration `yaml:"lock_timeout" env:"IDEMPOTENCY_LOCK_TIMEOUT"`
+	// Store is memory (per replica) or sql (shared by all replicas in the idempotency_keys table)
+	Store string `yaml:"store" env:"IDEMPOTENCY_STORE" flag:"idempotency-store" usage:"memory or sql"`
+}
+
+type Logging struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" usage:"text or json"`
+}
+
+type Health struct {
+	CheckTimeout time.Duration `yaml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT" flag:"health-check-timeout" usage:"timeout of each readiness check"`
+	// AdminToken is the bearer token required by /health/details, the endpoint is disabled without it and TrustedClients
+	AdminToken string `yaml:"admin_token" env:"HEALTH_ADMIN_TOKEN" secret:"true"`
+	// TrustedClients are the common names of client certificates (tls.client_auth) which may read /health/details without the token
+	TrustedClients []string `yaml:"trusted_clients" env:"HEALTH_TRUSTED_CLIENTS"`
+}
+
+type Tracing struct {
+	// Exporter is none, otlp (OTLP over http to Endpoint), stdout or file (json spans appended to File)
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"none, otlp, stdout or file"`
+	// Endpoint is the url of the OTLP/HTTP collector, headers can be set with OTEL_EXPORTER_OTLP_HEADERS
+	Endpoint    string `yaml:"endpoint" env:"TRACING_ENDPOINT" flag:"tracing-endpoint" usage:"OTLP/HTTP collector url, eg. http://localhost:4318"`
+	File        string `yaml:"file" env:"TRACING_FILE"`
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+	// SampleRatio of the traces started here, traces started by a caller follow the caller's sampling decision
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// loginRateLimit is shared by the login of every version, otherwise each of them would allow its own attempts
+var loginRateLimit = RateLimitPolicy{Name: "login", Algorithm: "sliding_window", Limit: 5, Window: time.Minute, Key: "ip"}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr: ":3000",
+			// the same chain as config.example.yaml
+			Middlewares: []string{"request_id", "tracing", "request_logger", "metrics", "recovery", "body_limit", "cors", "authentication",
+				"rate_limit", "response_time", "security_headers", "caching", "compression", "idempotency", "hpp"},
+			Hpp: HPP{
+				CheckQuery:                  true,
+				CheckBody:                   true,
+				CheckBodyOnlyForContentType: "application/x-www-form-urlencoded",
+				CheckJSON:                   true,
+				Whitelist:                   []string{"sortBy", "name", "age", "class"},
+				Repeatable:                  []string{"sortby"},
+				Duplicates:                  "first",
+			},
+			Compression: Compression{
+				MinSize:      1024,
+				ContentTypes: []string{"application/json", "application/problem+json", "application/yaml", "application/javascript", "image/svg+xml", "text/*"},
+			},
+			Caching:           Caching{MaxBodySize: 4 << 20},
+			ShutdownTimeout:   30 * time.Second,
+			MaxBodySize:       1 << 20,
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			WriteTimeout:      2 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+		},
+		TLS: TLS{
+			MinVersion:     "1.2",
+			ReloadInterval: 30 * time.Second,
+			ClientAuth:     "none",
+		},
+		Database: Database{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+			ConnMaxIdleTime: time.Minute,
+		},
+		Auth: Auth{TokenTTL: 15 * time.Minute},
+		Cors: Cors{
+			AllowedOrigins:   []string{"https://my-origin-url.com", "https://www.myfrontend.com", "https://localhost:3000"},
+			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key", "Idempotency-Key"},
+			ExposedHeaders:   []string{"Authorization", "Location", "X-Request-ID", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Idempotent-Replayed", "ETag", "Last-Modified"},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		},
+		RateLimit: RateLimit{
+			RateLimitPolicy: RateLimitPolicy{Algorithm: "token_bucket", Limit: 100, Window: time.Minute, Key: "ip"},
+			// brute forcing passwords is slowed down on the login of every version
+			Routes: map[string]RateLimitPolicy{
+				"POST /api/v1/execs/login": loginRateLimit,
+				"POST /execs/login":        loginRateLimit,
+			},
+			IdleTimeout: 10 * time.Minute,
+			Store:       "memory",
+		},
+		Idempotency: Idempotency{
+			TTL:         24 * time.Hour,
+			LockTimeout: 5 * time.Minute,
+			Store:       "sql",
+		},
+		Logging: Logging{Level: "info", Format: "text"},
+		Health:  Health{CheckTimeout: 2 * time.Second},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318",
+			File:        "traces.json",
+			ServiceName: "school-management",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.MaxBodySize >= 0, "server.max_body_size must not be negative")
+	for _, pattern := range slices.Sorted(maps.Keys(c.Server.BodyLimits)) {
+		check(c.Server.BodyLimits[pattern] > 0, "server.body_limits[%q] must be positive", pattern)
+	}
+	check(c.Server.ReadHeaderTimeout >= 0 && c.Server.ReadTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0, "server timeouts must not be negative")
+	if c.TLS.Enabled {
+		check(c.TLS.CertFile != "", "tls.cert_file is required when tls is enabled")
+		check(c.TLS.KeyFile != "", "tls.key_file is required when tls is enabled")
+		check(c.TLS.MinVersion == "1.2" || c.TLS.MinVersion == "1.3", "tls.min_version %q must be 1.2 or 1.3", c.TLS.MinVersion)
+		check(c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+		check(slices.Contains([]string{"none", "optional", "require"}, c.TLS.ClientAuth), "tls.client_auth %q must be none, optional or require", c.TLS.ClientAuth)
+		check(c.TLS.ClientAuth == "none" || c.TLS.ClientCAFile != "", "tls.client_ca_file is required for client certificate auth")
+		check(c.TLS.RedirectAddr == "" || c.TLS.RedirectAddr != c.Server.Addr, "tls.redirect_addr must differ from server.addr")
+	}
+	check(c.Database.DSN != "", "database.dsn (CONNECTION_STRING) is required")
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns, "database.max_idle_conns must not exceed database.max_open_conns")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime must not be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time must not be negative")
+	check(len(c.Auth.JWTSecret) >= 32, "auth.jwt_secret (JWT_SECRET) must be at least 32 bytes")
+	check(c.Auth.TokenTTL > 0, "auth.token_ttl must be positive")
+	for _, name := range slices.Sorted(maps.Keys(c.Auth.APIKeys)) {
+		check(name != "", "auth.api_keys names must not be empty")
+		check(len(c.Auth.APIKeys[name]) >= 32, "auth.api_keys[%q] must be at least 32 bytes", name)
+	}
+	check(!c.Cors.AllowCredentials || !slices.Contains(c.Cors.AllowedOrigins, "*"), "cors.allowed_origins must not contain \"*\" with cors.allow_credentials")
+	check(slices.Contains([]string{"first", "last", "reject"}, c.Server.Hpp.Duplicates), "server.hpp.duplicates must be first, last or reject")
+	check(c.Server.Compression.MinSize >= 0, "server.compression.min_size must not be negative")
+	check(c.Server.Caching.MaxBodySize >= 0, "server.caching.max_body_size must not be negative")
+	for _, pattern := range slices.Sorted(maps.Keys(c.Server.Caching.Policies)) {
+		check(c.Server.Caching.Policies[pattern] != "", "server.caching.policies[%q] must not be empty", pattern)
+	}
+	check(c.Cors.MaxAge >= 0, "cors.max_age must not be negative")
+	checkPolicy := func(name string, policy RateLimitPolicy) {
+		check(slices.Contains([]string{"token_bucket", "sliding_window"}, policy.Algorithm), "%s.algorithm %q must be token_bucket or sliding_window", name, policy.Algorithm)
+		check(slices.Contains([]string{"ip", "api_key", "exec"}, policy.Key), "%s.key %q must be ip, api_key or exec", name, policy.Key)
+		check(policy.Limit > 0, "%s.limit must be positive", name)
+		check(policy.Window > 0, "%s.window must be positive", name)
+	}
+	checkPolicy("rate_limit", c.RateLimit.RateLimitPolicy)
+	for _, pattern := range slices.Sorted(maps.Keys(c.RateLimit.Routes)) {
+		checkPolicy(fmt.Sprintf("rate_limit.routes[%q]", pattern), c.RateLimit.Routes[pattern])
+	}
+	check(c.RateLimit.IdleTimeout >= 0, "rate_limit.idle_timeout must not be negative")
+	check(c.RateLimit.Store == "memory" || c.RateLimit.Store == "sql", "rate_limit.store %q must be memory or sql", c.RateLimit.Store)
+	compression, idempotency := slices.Index(c.Server.Middlewares, "compression"), slices.Index(c.Server.Middlewares, "idempotency")
+	check(compression < 0 || idempotency < 0 || idempotency > compression,
+		"server.middlewares: idempotency must come after compression, otherwise it replays bodies encoded for another Accept-Encoding")
+	check(c.Idempotency.TTL > 0, "idempotency.ttl must be positive")
+	check(c.Idempotency.LockTimeout > 0, "idempotency.lock_timeout must be positive")
+	check(c.Idempotency.Store == "memory" || c.Idempotency.Store == "sql", "idempotency.store %q must be memory or sql", c.Idempotency.Store)
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, c.Logging.Level), "logging.level %q must be debug, info, warn or error", c.Logging.Level)
+	check(c.Health.CheckTimeout > 0, "health.check_timeout must be positive")
+	check(len(c.Health.TrustedClients) == 0 || (c.TLS.Enabled && c.TLS.ClientAuth != "none"), "health.trusted_clients needs tls with client_auth optional or require")
+	check(slices.Contains([]string{"text", "json"}, c.Logging.Format), "logging.format %q must be text or json", c.Logging.Format)
+	check(slices.Contains([]string{"none", "otlp", "stdout", "file"}, c.Tracing.Exporter), "tracing.exporter %q must be none, otlp, stdout or file", c.Tracing.Exporter)
+	check(c.Tracing.Exporter != "otlp" || c.Tracing.Endpoint != "", "tracing.endpoint is required for the otlp exporter")
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file is required for the file exporter")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	return errors.Join(errs...)
+}
+
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the config with the secret fields replaced
+func (c Config) Redacted() Config {
+	redactSecrets(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redactSecrets(val reflect.Value) {
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			redactSecrets(field)
+		case val.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "":
+			field.SetString(redacted)
+		case val.Type().Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.Map && field.Len() > 0:
+			// a copy, the map is shared with the config being printed
+			values := reflect.MakeMapWithSize(field.Type(), field.Len())
+			for _, key := range field.MapKeys() {
+				values.SetMapIndex(key, reflect.ValueOf(redacted))
+			}
+			field.Set(values)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+// without a config file the server has to run the chain config.example.yaml documents
+func TestDefaultMiddlewaresMatchExample(t *testing.T) {
+	example := Config{}
+	err := loadFile("../../config.example.yaml", &example)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults := Default().Server.Middlewares; !slices.Equal(example.Server.Middlewares, defaults) {
+		t.Errorf("default middlewares %v, config.example.yaml has %v", defaults, example.Server.Middlewares)
+	}
+}
+
+func TestValidateRequiresJWTSecret(t *testing.T) {
+	cfg := Default()
+	cfg.Database.DSN = "user:pass@tcp(localhost:3306)/school"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an error without auth.jwt_secret")
+	}
+	cfg.Auth.JWTSecret = "0123456789abcdef0123456789abcdef"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+}
+
+func TestValidateIdempotencyAfterCompression(t *testing.T) {
+	cfg := Default()
+	cfg.Database.DSN = "user:pass@tcp(localhost:3306)/school"
+	cfg.Auth.JWTSecret = "0123456789abcdef0123456789abcdef"
+	cfg.Server.Middlewares = []string{"request_id", "idempotency", "compression"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an error with idempotency before compression")
+	}
+	cfg.Server.Middlewares = []string{"request_id", "idempotency"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected a valid config without compression, got %v", err)
+	}
+}
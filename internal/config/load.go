@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigFile = "config.yaml"
+
+// Load layers defaults, the yaml config file, the environment (.env is loaded if present) and the flags in args.
+// It registers -config and the field flags on fs, so fs can hold other flags of the command too (eg. -routes).
+// Parse and validation errors are returned together, the config is returned even when invalid.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	config := Default()
+
+	configFile := fs.String("config", "", "path of the yaml config file (env CONFIG_FILE, default "+defaultConfigFile+")")
+	flagValues := make(map[string]string)
+	registerFlags(fs, reflect.TypeOf(config), flagValues)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return config, err
+	}
+
+	err = godotenv.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return config, fmt.Errorf("loading .env: %w", err)
+	}
+
+	var errs []error
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	// only a config file which was asked for has to exist
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+	err = loadFile(path, &config)
+	if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, applyEnv(reflect.ValueOf(&config).Elem())...)
+	errs = append(errs, applyFlags(reflect.ValueOf(&config).Elem(), flagValues)...)
+	errs = append(errs, config.Validate())
+
+	return config, errors.Join(errs...)
+}
+
+func loadFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// registerFlags adds a flag for every field with a flag tag, the raw values are applied after the environment
+func registerFlags(fs *flag.FlagSet, typ reflect.Type, values map[string]string) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			registerFlags(fs, field.Type, values)
+			continue
+		}
+
+		name := field.Tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		usage := field.Tag.Get("usage")
+		if env := field.Tag.Get("env"); env != "" {
+			usage += " (env " + env + ")"
+		}
+
+		set := func(value string) error {
+			values[name] = value
+			return nil
+		}
+		if field.Type.Kind() == reflect.Bool {
+			fs.BoolFunc(name, usage, set)
+		} else {
+			fs.Func(name, usage, set)
+		}
+	}
+}
+
+func applyEnv(val reflect.Value) []error {
+	var errs []error
+	for i := 0; i < val.NumField(); i++ {
+		field, fieldType := val.Field(i), val.Type().Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			errs = append(errs, applyEnv(field)...)
+			continue
+		}
+
+		name := fieldType.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			err := setField(field, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+func applyFlags(val reflect.Value, values map[string]string) []error {
+	var errs []error
+	for i := 0; i < val.NumField(); i++ {
+		field, fieldType := val.Field(i), val.Type().Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			errs = append(errs, applyFlags(field, values)...)
+			continue
+		}
+
+		name := fieldType.Tag.Get("flag")
+		if value, ok := values[name]; ok && name != "" {
+			err := setField(field, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setField parses a raw env/flag value into a config field
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == durationType:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(duration))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", value)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported config field type %s", field.Type())
+	}
+	return nil
+}
+
+// Print writes the config as yaml with its secrets redacted
+func (c Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(c.Redacted())
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,60 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// process wide state of the server lifecycle, background workers run on the worker context
+// and the server reports not ready once draining started
+
+// Version is the build version, set with -ldflags "-X school-management/internal/lifecycle.Version=v1.2.3"
+var Version = "dev"
+
+// StartedAt is the start time of the process
+var StartedAt = time.Now()
+
+var (
+	workerCtx, stopWorkers = context.WithCancel(context.Background())
+	workers                sync.WaitGroup
+	draining               atomic.Bool
+)
+
+// Go runs a background worker, ctx is cancelled on shutdown and the worker is waited for by StopWorkers
+func Go(worker func(ctx context.Context)) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		worker(workerCtx)
+	}()
+}
+
+// StartDrain marks the server as shutting down
+func StartDrain() {
+	draining.Store(true)
+}
+
+// Draining reports whether the server is shutting down
+func Draining() bool {
+	return draining.Load()
+}
+
+// StopWorkers cancels the worker context and waits for the workers to return, or until ctx is done
+func StopWorkers(ctx context.Context) error {
+	stopWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,9 @@
+package models
+
+// BulkItemResult is the outcome of one item of a non-atomic bulk request (?atomic=false)
+type BulkItemResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
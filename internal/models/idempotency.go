@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the request holding an Idempotency-Key and, once it completed, its response.
+// Key and Fingerprint are sha256 hex digests, the key is scoped to the client's credentials.
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+
+	Completed bool
+	Status    int
+	Header    map[string][]string
+	Body      []byte
+
+	CreatedAt time.Time
+	// ExpiresAt frees the key, in-flight requests hold it for the lock timeout, completed ones for the ttl
+	ExpiresAt time.Time
+}
@@ -0,0 +1,32 @@
+package models
+
+// ImportJob tracks a CSV/XLSX import so clients can poll its progress (GET /imports/{id})
+type ImportJob struct {
+	ID            int              `json:"id"`
+	Entity        string           `json:"entity"`
+	FileName      string           `json:"file_name"`
+	Status        string           `json:"status"`
+	TotalRows     int              `json:"total_rows"`
+	ProcessedRows int              `json:"processed_rows"`
+	CreatedRows   int              `json:"created_rows"`
+	UpdatedRows   int              `json:"updated_rows"`
+	FailedRows    int              `json:"failed_rows"`
+	Errors        []ImportRowError `json:"errors"`
+	RequestID     string           `json:"request_id,omitempty"` // of the upload which started the job
+	CreatedAt     string           `json:"created_at,omitempty"`
+	UpdatedAt     string           `json:"updated_at,omitempty"`
+}
+
+// ImportRowError reports a problem with a single row of an uploaded file, Row is the spreadsheet row number (header is row 1)
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
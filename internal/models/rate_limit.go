@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// RateLimitState is the rate limiter state of one client under one policy,
+// the token bucket and the sliding window algorithms use their own fields
+type RateLimitState struct {
+	Tokens     float64
+	LastRefill time.Time
+
+	WindowStart time.Time
+	PrevCount   int
+	CurrCount   int
+
+	LastSeen time.Time
+}
@@ -0,0 +1,115 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"school-management/internal/models"
+	"school-management/pkg/utils"
+)
+
+// keeps the number of placeholders of a single INSERT well below the 65535 limit of MySQL/MariaDB
+const bulkInsertBatchSize = 500
+
+// insertRowsAtomic inserts all rows in a single transaction using multi-row INSERTs, either every row is inserted or none.
+// Returns the generated ids in the same order as rows.
+func insertRowsAtomic(ctx context.Context, db *sql.DB, table, entity string, model interface{}, rows [][]interface{}) ([]int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error starting transaction")
+	}
+
+	// without consecutive ids only single row inserts tell which id a row got, they are still all or nothing
+	batchSize := bulkInsertBatchSize
+	if !consecutiveInsertIDs(ctx, tx) {
+		batchSize = 1
+	}
+
+	ids := make([]int, 0, len(rows))
+	for start := 0; start < len(rows); start += batchSize {
+		end := min(start+batchSize, len(rows))
+
+		var args []interface{}
+		for _, values := range rows[start:end] {
+			args = append(args, values...)
+		}
+
+		res, err := tx.ExecContext(ctx, utils.GenerateBulkInsertQuery(table, model, end-start), args...)
+		if err != nil {
+			if conflictErr := handleDuplicateKeyError(ctx, tx, err, table, entity, model); conflictErr != nil {
+				tx.Rollback()
+				return nil, conflictErr
+			}
+			tx.Rollback()
+			return nil, utils.ErrorHandler(ctx, err, fmt.Sprintf("Error adding %ss", entity))
+		}
+
+		// for a multi-row insert LastInsertId is the id of the first row, the rest are consecutive (see consecutiveInsertIDs)
+		firstID, err := res.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return nil, utils.ErrorHandler(ctx, err, fmt.Sprintf("Error adding %ss", entity))
+		}
+		for i := 0; i < end-start; i++ {
+			ids = append(ids, int(firstID)+i)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Rollback()
+		return nil, utils.ErrorHandler(ctx, err, "Error commiting transaction")
+	}
+	return ids, nil
+}
+
+// consecutiveInsertIDs reports whether the rows of a multi-row INSERT are guaranteed consecutive ids. That needs
+// innodb_autoinc_lock_mode 0 or 1 (2 interleaves concurrent inserts, the default since MySQL 8) and an
+// auto_increment_increment of 1 (galera clusters step by the number of nodes). Unknown settings count as no.
+func consecutiveInsertIDs(ctx context.Context, tx *sql.Tx) bool {
+	var lockMode, increment int
+	err := tx.QueryRowContext(ctx, "SELECT @@GLOBAL.innodb_autoinc_lock_mode, @@SESSION.auto_increment_increment").Scan(&lockMode, &increment)
+	if err != nil {
+		utils.Logger(ctx).Warn("error reading the auto increment settings, inserting row by row", "error", err)
+		return false
+	}
+	return lockMode <= 1 && increment == 1
+}
+
+// insertRowsPartial inserts every row on its own, a failing row does not affect the others.
+// Returns one result per row in the same order as rows.
+func insertRowsPartial(ctx context.Context, db *sql.DB, table, entity string, model interface{}, rows [][]interface{}) ([]models.BulkItemResult, error) {
+	stmt, err := db.PrepareContext(ctx, utils.GenerateInsertQuery(table, model))
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, fmt.Sprintf("Error adding %ss", entity))
+	}
+	defer stmt.Close()
+
+	results := make([]models.BulkItemResult, len(rows))
+	for i, values := range rows {
+		results[i] = models.BulkItemResult{Index: i}
+
+		res, err := stmt.ExecContext(ctx, values...)
+		if err != nil {
+			if conflictErr := handleDuplicateKeyError(ctx, db, err, table, entity, model); conflictErr != nil {
+				results[i].Status = http.StatusConflict
+				results[i].Error = conflictErr.Error()
+				continue
+			}
+			results[i].Status = http.StatusInternalServerError
+			results[i].Error = utils.ErrorHandler(ctx, err, fmt.Sprintf("Error adding %s", entity)).Error()
+			continue
+		}
+
+		lastID, err := res.LastInsertId()
+		if err != nil {
+			results[i].Status = http.StatusInternalServerError
+			results[i].Error = utils.ErrorHandler(ctx, err, fmt.Sprintf("Error adding %s", entity)).Error()
+			continue
+		}
+		results[i].Status = http.StatusCreated
+		results[i].ID = int(lastID)
+	}
+	return results, nil
+}
@@ -0,0 +1,44 @@
+package sqlconnect
+
+import (
+	"context"
+	"school-management/internal/models"
+	"school-management/pkg/utils"
+	"slices"
+	"testing"
+)
+
+func TestInsertRowsAtomicReturnsTheAssignedIDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		lockMode  int
+		increment int
+		inserts   []int64
+	}{
+		{"consecutive lock mode", 1, 1, []int64{3}},
+		{"interleaved lock mode", 2, 1, []int64{1, 1, 1}},
+		{"galera offsets", 1, 3, []int64{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeServer{lockMode: tt.lockMode, increment: tt.increment, nextID: 10}
+			db := newFakeDB(server)
+			defer db.Close()
+
+			var rows [][]interface{}
+			for _, email := range []string{"a@school.com", "b@school.com", "c@school.com"} {
+				rows = append(rows, utils.GetStructValues(models.Student{FirstName: "A", LastName: "B", Email: email, Class: "9A"}))
+			}
+			ids, err := insertRowsAtomic(context.Background(), db, "students", "student", models.Student{}, rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ids, server.assigned) {
+				t.Errorf("expected the ids %v, got %v", server.assigned, ids)
+			}
+			if !slices.Equal(server.inserts, tt.inserts) {
+				t.Errorf("expected inserts of %v rows, got %v", tt.inserts, server.inserts)
+			}
+		})
+	}
+}
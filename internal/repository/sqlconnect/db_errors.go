@@ -0,0 +1,95 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"regexp"
+	"school-management/pkg/utils"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ER_DUP_ENTRY - returned by MySQL/MariaDB when an insert or update violates a unique index
+const mysqlErrDuplicateEntry = 1062
+
+// Duplicate entry 'john@school.com' for key 'email'  (MySQL 8 reports the key as 'teachers.email')
+var duplicateEntryPattern = regexp.MustCompile(`Duplicate entry '(.*)' for key '([^']+)'`)
+
+// queryRower is satisfied by *sql.DB, *sql.Tx and *sql.Conn
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func isDuplicateKeyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry
+}
+
+// handleDuplicateKeyError turns a duplicate key error into a *utils.ConflictError naming the conflicting field, value and the row already holding it.
+// Any other error is returned as nil so the caller can fall back to its generic message.
+func handleDuplicateKeyError(ctx context.Context, db queryRower, err error, table, entity string, model interface{}) error {
+	if !isDuplicateKeyError(err) {
+		return nil
+	}
+
+	var mysqlErr *mysql.MySQLError
+	errors.As(err, &mysqlErr)
+
+	conflict := &utils.ConflictError{Entity: entity, Field: "value"}
+
+	matches := duplicateEntryPattern.FindStringSubmatch(mysqlErr.Message)
+	if len(matches) == 3 {
+		conflict.Value = matches[1]
+		conflict.Field = columnFromIndexName(matches[2], table, model)
+	}
+
+	// only look up the existing row when the column is a real column of the model (never interpolate raw index names)
+	if isModelColumn(conflict.Field, model) {
+		var existingID int
+		lookupErr := db.QueryRowContext(ctx, "SELECT id FROM "+table+" WHERE "+conflict.Field+" = ?", conflict.Value).Scan(&existingID)
+		if lookupErr == nil {
+			conflict.ExistingID = existingID
+		}
+	}
+
+	utils.ErrorHandler(ctx, err, conflict.Error())
+	return conflict
+}
+
+// columnFromIndexName maps a unique index name back to the column it covers,
+// eg. 'email', 'teachers.email', 'email_UNIQUE', 'uk_email' all map to email
+func columnFromIndexName(indexName, table string, model interface{}) string {
+	name := indexName
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if isModelColumn(name, model) {
+		return name
+	}
+
+	lower := strings.ToLower(name)
+	for _, prefix := range []string{"uniq_", "unique_", "uk_", "ux_", "idx_", strings.ToLower(table) + "_"} {
+		lower = strings.TrimPrefix(lower, prefix)
+	}
+	for _, suffix := range []string{"_unique", "_uniq", "_uk", "_idx", "_key"} {
+		lower = strings.TrimSuffix(lower, suffix)
+	}
+	if isModelColumn(lower, model) {
+		return lower
+	}
+	return name
+}
+
+func isModelColumn(column string, model interface{}) bool {
+	modelType := reflect.TypeOf(model)
+	for i := 0; i < modelType.NumField(); i++ {
+		dbTag := strings.TrimSuffix(modelType.Field(i).Tag.Get("db"), ",omitempty")
+		if dbTag != "" && dbTag == column {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"school-management/pkg/utils"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentedConnector traces every statement and prefixes the statements of a request with a comment carrying
+// its request id, so the slow query log and the process list can be correlated with the api logs:
+// /* request_id=01J9Z3... */ SELECT id, first_name ... FROM students
+type instrumentedConnector struct {
+	driver.Connector
+	dbName string
+	addr   string
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, connector: c}, nil
+}
+
+func commentQuery(ctx context.Context, query string) string {
+	requestID := utils.RequestID(ctx)
+	if requestID == "" {
+		return query
+	}
+	// the middleware only accepts safe ids, this keeps any other caller from ending the comment early
+	requestID = strings.ReplaceAll(requestID, "*/", "")
+	return "/* request_id=" + requestID + " */ " + query
+}
+
+// queryTable finds the table of simple statements, joins and subqueries only report the first one
+var queryTable = regexp.MustCompile("(?is)^\\s*(?:select\\b.*?\\bfrom|insert\\s+(?:ignore\\s+)?into|replace\\s+into|update|delete\\s+from)\\s+`?(\\w+)")
+
+// startStatementSpan starts a client span named like "SELECT students", the query text has placeholders
+// instead of values so it is recorded as it is
+func (c instrumentedConnector) startStatementSpan(ctx context.Context, query string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	var operation string
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+
+	name := operation
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemNameMariaDB,
+		semconv.DBOperationName(operation),
+		semconv.DBQueryText(query),
+		semconv.DBNamespace(c.dbName),
+		semconv.ServerAddress(c.addr),
+	}
+	if match := queryTable.FindStringSubmatch(query); match != nil {
+		name += " " + match[1]
+		attrs = append(attrs, semconv.DBCollectionName(match[1]))
+	}
+	opts = append(opts, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return tracer.Start(ctx, name, opts...)
+}
+
+func endStatementSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// instrumentedConn forwards the optional driver interfaces database/sql looks for to the wrapped connection
+type instrumentedConn struct {
+	driver.Conn
+	connector instrumentedConnector
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, commentQuery(ctx, query))
+	} else {
+		stmt, err = c.Conn.Prepare(commentQuery(ctx, query))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, connector: c.connector, query: query}, nil
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	// the mysql driver skips the fast path for queries with args (unless interpolateParams is set) and database/sql
+	// retries them as prepared statements, the span is only started once the driver took the query so the retry
+	// isn't traced twice
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, commentQuery(ctx, query), args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	// the span ends once the query ran, reading the rows isn't part of it
+	_, span := c.connector.startStatementSpan(ctx, query, trace.WithTimestamp(start))
+	endStatementSpan(span, err)
+	return rows, err
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	// same as QueryContext, statements skipped by the driver are traced by instrumentedStmt
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, commentQuery(ctx, query), args)
+	if errors.Is(err, driver.ErrSkip) {
+		return nil, err
+	}
+	_, span := c.connector.startStatementSpan(ctx, query, trace.WithTimestamp(start))
+	endStatementSpan(span, err)
+	return result, err
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *instrumentedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+// instrumentedStmt traces every execution of a prepared statement (eg. the rows of a bulk insert)
+type instrumentedStmt struct {
+	driver.Stmt
+	connector instrumentedConnector
+	query     string
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errors.New("sqlconnect: driver statement doesn't support ExecContext")
+	}
+	ctx, span := s.connector.startStatementSpan(ctx, s.query)
+	result, err := execer.ExecContext(ctx, args)
+	endStatementSpan(span, err)
+	return result, err
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errors.New("sqlconnect: driver statement doesn't support QueryContext")
+	}
+	ctx, span := s.connector.startStatementSpan(ctx, s.query)
+	rows, err := queryer.QueryContext(ctx, args)
+	endStatementSpan(span, err)
+	return rows, err
+}
+
+func (s *instrumentedStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,220 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spans records the spans of the package tracer, the global provider can only be installed once
+var spans = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	os.Exit(m.Run())
+}
+
+// fakeServer plays the database behind fakeConn, it hands out auto increment ids like InnoDB does
+type fakeServer struct {
+	lockMode  int
+	increment int
+	nextID    int64
+	assigned  []int   // ids of the inserted rows in insert order
+	inserts   []int64 // rows per INSERT statement
+	lockFree  bool    // GET_LOCK answers 1, otherwise 0 as if another session held it past the timeout
+	version   int64   // MAX(version) of schema_migrations
+	executed  []string
+}
+
+// query answers the lock and schema version queries of Migrate, anything else has no rows
+func (s *fakeServer) query(query string) *fakeRows {
+	s.executed = append(s.executed, query)
+	switch {
+	case strings.Contains(query, "GET_LOCK"):
+		locked := int64(0)
+		if s.lockFree {
+			locked = 1
+		}
+		return &fakeRows{columns: []string{"locked"}, values: [][]driver.Value{{locked}}}
+	case strings.Contains(query, "MAX(version)"):
+		return &fakeRows{columns: []string{"version"}, values: [][]driver.Value{{s.version}}}
+	}
+	return &fakeRows{}
+}
+
+func (s *fakeServer) insert(query string) driver.Result {
+	rows := int64(strings.Count(query, "(?"))
+	first := s.nextID
+	for i := int64(0); i < rows; i++ {
+		s.assigned = append(s.assigned, int(s.nextID))
+		s.nextID += int64(s.increment)
+		// lock mode 2 lets concurrent inserts take ids in between the rows of a multi-row insert
+		if s.lockMode == 2 && rows > 1 {
+			s.nextID += int64(s.increment)
+		}
+	}
+	s.inserts = append(s.inserts, rows)
+	return fakeResult{lastInsertID: first, rowsAffected: rows}
+}
+
+func newFakeDB(server *fakeServer) *sql.DB {
+	return sql.OpenDB(instrumentedConnector{Connector: fakeConnector{server}, dbName: "school", addr: "localhost:3306"})
+}
+
+// fakeConnector behaves like go-sql-driver/mysql without interpolateParams: statements with args skip the fast path
+type fakeConnector struct {
+	server *fakeServer
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{c.server}, nil }
+func (fakeConnector) Driver() driver.Driver                          { return nil }
+
+type fakeConn struct {
+	server *fakeServer
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.server, query}, nil }
+func (fakeConn) Close() error                                { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                   { return fakeTx{}, nil }
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	if strings.Contains(query, "@@GLOBAL.innodb_autoinc_lock_mode") {
+		return &fakeRows{columns: []string{"lock_mode", "increment"}, values: [][]driver.Value{{int64(c.server.lockMode), int64(c.server.increment)}}}, nil
+	}
+	if c.server != nil {
+		return c.server.query(query), nil
+	}
+	return &fakeRows{}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	if c.server != nil {
+		c.server.executed = append(c.server.executed, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	server *fakeServer
+	query  string
+}
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error)  { return &fakeRows{}, nil }
+
+func (s fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if s.server != nil {
+		s.server.executed = append(s.server.executed, s.query)
+		if strings.HasPrefix(s.query, "INSERT") {
+			return s.server.insert(s.query), nil
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if s.server != nil {
+		return s.server.query(s.query), nil
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (*fakeRows) Close() error        { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestStatementsAreTracedOnce(t *testing.T) {
+	db := newFakeDB(&fakeServer{})
+	defer db.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func() error
+		span string
+	}{
+		{"query without args", func() error {
+			rows, err := db.QueryContext(ctx, "SELECT id FROM students")
+			if err == nil {
+				rows.Close()
+			}
+			return err
+		}, "SELECT students"},
+		{"query with args", func() error {
+			rows, err := db.QueryContext(ctx, "SELECT id FROM students WHERE id = ?", 1)
+			if err == nil {
+				rows.Close()
+			}
+			return err
+		}, "SELECT students"},
+		{"exec without args", func() error {
+			_, err := db.ExecContext(ctx, "DELETE FROM teachers")
+			return err
+		}, "DELETE teachers"},
+		{"exec with args", func() error {
+			_, err := db.ExecContext(ctx, "UPDATE execs SET email = ? WHERE id = ?", "a@b.c", 1)
+			return err
+		}, "UPDATE execs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans.Reset()
+			if err := tt.run(); err != nil {
+				t.Fatal(err)
+			}
+
+			ended := spans.Ended()
+			if len(ended) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(ended))
+			}
+			if ended[0].Name() != tt.span {
+				t.Errorf("expected span %q, got %q", tt.span, ended[0].Name())
+			}
+			if len(ended[0].Events()) != 0 {
+				t.Errorf("expected no recorded error, got %v", ended[0].Events())
+			}
+		})
+	}
+}
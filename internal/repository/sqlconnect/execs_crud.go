@@ -1,6 +1,7 @@
 package sqlconnect
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
@@ -11,30 +12,46 @@ import (
 	"school-management/internal/models"
 	"school-management/pkg/utils"
 	"strconv"
-	"strings"
 
 	"golang.org/x/crypto/argon2"
 )
 
-func GetExecByIdDbHandler(id int) (models.Exec, error) {
+func GetExecByIdDbHandler(ctx context.Context, id int) (models.Exec, error) {
+	ctx, span := startSpan(ctx, "GetExecByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "Error connecting to DB")
+		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error connecting to DB")
 	}
-	defer db.Close()
 
 	var exec models.Exec
-	err = db.QueryRow("SELECT id, first_name, last_name, email, username FROM execs WHERE id = ?", id).Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username)
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username FROM execs WHERE id = ?", id).Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username)
 
 	if err == sql.ErrNoRows {
-		return models.Exec{}, utils.ErrorHandler(err, "exec Not found")
+		return models.Exec{}, &utils.NotFoundError{Entity: "Exec"}
 	} else if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "Error retrieving exec")
+		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error retrieving exec")
 	}
 	return exec, nil
 }
 
 func GetExecsDbHandler(execs []models.Exec, r *http.Request) ([]models.Exec, error) {
+	err := StreamExecsDbHandler(r, func(exec models.Exec) error {
+		execs = append(execs, exec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return execs, nil
+}
+
+// StreamExecsDbHandler runs the filtered and sorted exec query and calls onRow for every row straight from the cursor,
+// so large exports never hold the whole table in memory. An error returned by onRow stops the iteration.
+func StreamExecsDbHandler(r *http.Request, onRow func(exec models.Exec) error) error {
+	ctx, span := startSpan(r.Context(), "StreamExecsDbHandler")
+	defer span.End()
 
 	query := "SELECT id, first_name, last_name, email, username FROM execs WHERE 1 = 1"
 	var args []interface{}
@@ -46,134 +63,163 @@ func GetExecsDbHandler(execs []models.Exec, r *http.Request) ([]models.Exec, err
 
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error querying DB")
+		return utils.ErrorHandler(ctx, err, "Error querying DB")
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var exec models.Exec
 		err = rows.Scan(&exec.ID, &exec.FirstName, &exec.LastName, &exec.Email, &exec.Username)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "Error scaning row from db")
+			return utils.ErrorHandler(ctx, err, "Error scaning row from db")
+		}
+		err = onRow(exec)
+		if err != nil {
+			return err
 		}
-		execs = append(execs, exec)
 	}
-	return execs, nil
+	err = rows.Err()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "rows error")
+	}
+	return nil
 }
+func AddExecsDbHandler(ctx context.Context, addedExecs []models.Exec, newExecs []models.Exec) ([]models.Exec, error) {
+	ctx, span := startSpan(ctx, "AddExecsDbHandler")
+	defer span.End()
 
-func AddExecsDbHandler(addedExecs []models.Exec, newExecs []models.Exec) ([]models.Exec, error) {
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	// stmt, err := db.Prepare("INSERT INTO Execs (first_name, last_name, email) VALUES(?,?,?,?,?)")
-	stmt, err := db.Prepare(utils.GenerateInsertQuery("execs", models.Exec{}))
-	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error updating Execs")
+	rows := make([][]interface{}, len(newExecs))
+	for i := range newExecs {
+		newExecs[i].Password, err = hashPassword(ctx, newExecs[i].Password)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = utils.GetStructValues(newExecs[i])
 	}
-	defer stmt.Close()
 
-	for _, newExec := range newExecs {
-		if newExec.Password == "" {
-			return nil, utils.ErrorHandler(errors.New("please is blank"), "please enter password")
-		}
+	// all or nothing - a single failing exec rolls back the whole batch
+	ids, err := insertRowsAtomic(ctx, db, "execs", "exec", models.Exec{}, rows)
+	if err != nil {
+		return nil, err
+	}
 
-		salt := make([]byte, 16)
-		_, err := rand.Read(salt)
-		if err != nil {
-			return nil, utils.ErrorHandler(errors.New("failed to generate sale"), "error adding data")
-		}
+	for i, newExec := range newExecs {
+		newExec.ID = ids[i]
+		addedExecs = append(addedExecs, newExec)
+	}
+	return addedExecs, nil
+}
 
-		hash := argon2.IDKey([]byte(newExec.Password), salt, 1, 64*1024, 4, 32)
-		saltBase64 := base64.StdEncoding.EncodeToString(salt)
-		hashBase64 := base64.StdEncoding.EncodeToString(hash)
+// AddExecsPartialDbHandler inserts each exec independently and reports the outcome per exec
+func AddExecsPartialDbHandler(ctx context.Context, newExecs []models.Exec) ([]models.BulkItemResult, error) {
+	ctx, span := startSpan(ctx, "AddExecsPartialDbHandler")
+	defer span.End()
 
-		encodedHash := fmt.Sprintf("%s, %s", saltBase64, hashBase64)
-		newExec.Password = encodedHash
+	db, err := ConnectDB()
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
 
-		values := utils.GetStructValues(newExec)
-		res, err := stmt.Exec(values...)
-		if err != nil {
-			if strings.Contains(err.Error(), "duplicate") {
-				return nil, utils.ErrorHandler(err, "")
-			}
-			return nil, utils.ErrorHandler(err, "Error updating Exec")
-		}
-		lastID, err := res.LastInsertId()
+	rows := make([][]interface{}, len(newExecs))
+	for i, newExec := range newExecs {
+		newExec.Password, err = hashPassword(ctx, newExec.Password)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "Error updating Exec")
+			return nil, err
 		}
-		newExec.ID = int(lastID)
-		addedExecs = append(addedExecs, newExec)
+		rows[i] = utils.GetStructValues(newExec)
 	}
-	return addedExecs, nil
+	return insertRowsPartial(ctx, db, "execs", "exec", models.Exec{}, rows)
+}
+
+func hashPassword(ctx context.Context, password string) (string, error) {
+	if password == "" {
+		return "", utils.ErrorHandler(ctx, errors.New("please is blank"), "please enter password")
+	}
+
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", utils.ErrorHandler(ctx, errors.New("failed to generate sale"), "error adding data")
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	saltBase64 := base64.StdEncoding.EncodeToString(salt)
+	hashBase64 := base64.StdEncoding.EncodeToString(hash)
+
+	return fmt.Sprintf("%s, %s", saltBase64, hashBase64), nil
 }
+func DeleteExecByIdDbHandler(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteExecByIdDbHandler")
+	defer span.End()
 
-func DeleteExecByIdDbHandler(id int) error {
 	db, err := ConnectDB()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM execs WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, "DELETE FROM execs WHERE id = ?", id)
 	if err != nil {
-		return utils.ErrorHandler(err, "Error deleting Exec")
+		return utils.ErrorHandler(ctx, err, "Error deleting Exec")
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error deleting Exec")
+		return utils.ErrorHandler(ctx, err, "Error deleting Exec")
 	}
 
 	if rowsAffected == 0 {
-		return utils.ErrorHandler(err, "Exec not found")
+		return &utils.NotFoundError{Entity: "Exec"}
 	}
 	return nil
 }
 
-func PatchExecsDbHandler(updates []map[string]interface{}) error {
+func PatchExecsDbHandler(ctx context.Context, updates []map[string]interface{}) error {
+	ctx, span := startSpan(ctx, "PatchExecsDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
 	// transactions are used for commands which should either execute all or all fail
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "Error starting transaction")
+		return utils.ErrorHandler(ctx, err, "Error starting transaction")
 	}
 
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Error retrieving id")
+			return utils.ErrorHandler(ctx, err, "Error retrieving id")
 		}
 
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Invalid Exec Id")
+			return utils.ErrorHandler(ctx, err, "Invalid Exec Id")
 		}
 
 		var execFromDB models.Exec
-		err = db.QueryRow("SELECT id, first_name, last_name, email, username, role FROM execs WHERE id = ?", id).Scan(&execFromDB.ID, &execFromDB.FirstName, &execFromDB.LastName, &execFromDB.Email, &execFromDB.Username, &execFromDB.Role)
+		err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, role FROM execs WHERE id = ?", id).Scan(&execFromDB.ID, &execFromDB.FirstName, &execFromDB.LastName, &execFromDB.Email, &execFromDB.Username, &execFromDB.Role)
 
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Exec not found")
+				return &utils.NotFoundError{Entity: "Exec"}
 			}
-			return utils.ErrorHandler(err, "Error updating Execss")
+			return utils.ErrorHandler(ctx, err, "Error updating Execss")
 		}
 
 		execVal := reflect.ValueOf(&execFromDB).Elem()
@@ -194,17 +240,21 @@ func PatchExecsDbHandler(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							return utils.ErrorHandler(err, "Error getting filed value")
+							return utils.ErrorHandler(ctx, err, "Error getting filed value")
 						}
 					}
 					break
 				}
 			}
 		}
-		_, err = tx.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ? WHERE id = ?", execFromDB.FirstName, execFromDB.LastName, execFromDB.Email, execFromDB.Username, execFromDB.Role, execFromDB.ID)
+		_, err = tx.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ? WHERE id = ?", execFromDB.FirstName, execFromDB.LastName, execFromDB.Email, execFromDB.Username, execFromDB.Role, execFromDB.ID)
 		if err != nil {
+			if conflictErr := handleDuplicateKeyError(ctx, tx, err, "execs", "exec", models.Exec{}); conflictErr != nil {
+				tx.Rollback()
+				return conflictErr
+			}
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Error updating Execss")
+			return utils.ErrorHandler(ctx, err, "Error updating Execss")
 		}
 	}
 
@@ -212,68 +262,77 @@ func PatchExecsDbHandler(updates []map[string]interface{}) error {
 	err = tx.Commit()
 	if err != nil {
 		tx.Rollback()
-		return utils.ErrorHandler(err, "Error commiting transaction")
+		return utils.ErrorHandler(ctx, err, "Error commiting transaction")
 	}
 	return nil
 }
 
-func PatchExecByIdDbHandler(id int, updates map[string]string) (models.Exec, error) {
+// PatchExecByIdDbHandler locks the exec, lets patch change it and stores it, all or nothing
+func PatchExecByIdDbHandler(ctx context.Context, id int, patch func(exec *models.Exec) error) (models.Exec, error) {
+	ctx, span := startSpan(ctx, "PatchExecByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "Error Connecting to DB")
+		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
+
+	// the row stays locked until the patched exec is stored, so concurrent patches apply one after the other
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error starting transaction")
+	}
+	defer tx.Rollback()
 
 	var existingExec models.Exec
-	err = db.QueryRow("SELECT id, first_name, last_name, email, username, role FROM execs WHERE id = ?", id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role)
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, role, inactive_status FROM execs WHERE id = ? FOR UPDATE", id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role, &existingExec.InactiveStatus)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Exec{}, utils.ErrorHandler(err, "Exec not found")
+			return models.Exec{}, &utils.NotFoundError{Entity: "Exec"}
 		}
-		return models.Exec{}, utils.ErrorHandler(err, "Error updating Exec")
+		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error updating exec")
 	}
 
-	execVal := reflect.ValueOf(&existingExec).Elem()
-	execType := execVal.Type()
+	// an invalid patch is the client's fault, its error is returned as it is
+	err = patch(&existingExec)
+	if err != nil {
+		return models.Exec{}, err
+	}
 
-	for k, v := range updates {
-		for i := 0; i < execVal.NumField(); i++ {
-			field := execType.Field(i)
-			if field.Tag.Get("json") == k+",omitempty" {
-				if execVal.Field(i).CanSet() {
-					fieldVal := execVal.Field(i)
-					fieldVal.Set(reflect.ValueOf(v).Convert(execVal.Field(i).Type()))
-				}
-			}
+	_, err = tx.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ?, inactive_status = ? WHERE id = ?", existingExec.FirstName, existingExec.LastName, existingExec.Email, existingExec.Username, existingExec.Role, existingExec.InactiveStatus, existingExec.ID)
+	if err != nil {
+		if conflictErr := handleDuplicateKeyError(ctx, tx, err, "execs", "exec", models.Exec{}); conflictErr != nil {
+			return models.Exec{}, conflictErr
 		}
+		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error updating exec")
 	}
 
-	_, err = db.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ? WHERE id = ?", existingExec.FirstName, existingExec.LastName, existingExec.Email, existingExec.Username, existingExec.Role, existingExec.ID)
+	err = tx.Commit()
 	if err != nil {
-		return models.Exec{}, utils.ErrorHandler(err, "Error updating exec")
+		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error committing transaction")
 	}
 	return existingExec, nil
 }
 
-// func UpdateExecByIdDbHandle(id int, updatedExec models.Exec) (models.Exec, error) {
+// func UpdateExecByIdDbHandle(ctx context.Context, id int, updatedExec models.Exec) (models.Exec, error) {
 // 	db, err := ConnectDB()
 // 	if err != nil {
-// 		return models.Exec{}, utils.ErrorHandler(err, "Error Connecting to DB")
+// 		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 // 	}
 // 	defer db.Close()
 
 // 	var existingExec models.Exec
-// 	err = db.QueryRow("SELECT id, first_name, last_name, email, username, role FROM execs WHERE id = ?", id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role, id)
+// 	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, username, role FROM execs WHERE id = ?", id).Scan(&existingExec.ID, &existingExec.FirstName, &existingExec.LastName, &existingExec.Email, &existingExec.Username, &existingExec.Role, id)
 // 	if err == sql.ErrNoRows {
-// 		return models.Exec{}, utils.ErrorHandler(err, "Exec not found")
+// 		return models.Exec{}, utils.ErrorHandler(ctx, err, "Exec not found")
 // 	} else if err != nil {
-// 		return models.Exec{}, utils.ErrorHandler(err, "Error updating Exec")
+// 		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error updating Exec")
 // 	}
 
 // 	updatedExec.ID = existingExec.ID
-// 	_, err = db.Exec("UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ? WHERE id = ?", updatedExec.FirstName, updatedExec.LastName, updatedExec.Email, updatedExec.Username, updatedExec.Role, updatedExec.ID)
+// 	_, err = db.ExecContext(ctx, "UPDATE execs SET first_name = ?, last_name = ?, email = ?, username = ?, role = ? WHERE id = ?", updatedExec.FirstName, updatedExec.LastName, updatedExec.Email, updatedExec.Username, updatedExec.Role, updatedExec.ID)
 // 	if err != nil {
-// 		return models.Exec{}, utils.ErrorHandler(err, "Error updating Exec")
+// 		return models.Exec{}, utils.ErrorHandler(ctx, err, "Error updating Exec")
 // 	}
 // 	return updatedExec, nil
 // }
@@ -0,0 +1,153 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"school-management/internal/models"
+	"school-management/pkg/utils"
+	"time"
+)
+
+// times are stored as unix nanoseconds like in rate_limits, headers as a json object
+const createIdempotencyKeysTable = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idem_key CHAR(64) PRIMARY KEY,
+	fingerprint CHAR(64) NOT NULL,
+	completed BOOLEAN NOT NULL DEFAULT FALSE,
+	status INT NOT NULL DEFAULT 0,
+	header TEXT,
+	body MEDIUMBLOB,
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL,
+	INDEX idx_idempotency_keys_expires_at (expires_at)
+)`
+
+// ClaimIdempotencyKeyDbHandler stores claim as the in-flight request of its key. If an unexpired record holds the key
+// already it is returned instead, the row lock makes concurrent claims of the same key from all replicas atomic.
+func ClaimIdempotencyKeyDbHandler(ctx context.Context, claim models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	ctx, span := startSpan(ctx, "ClaimIdempotencyKeyDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT IGNORE INTO idempotency_keys (idem_key, fingerprint, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		claim.Key, claim.Fingerprint, claim.CreatedAt.UnixNano(), claim.ExpiresAt.UnixNano())
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error claiming idempotency key")
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error claiming idempotency key")
+	}
+
+	if inserted == 0 {
+		existing, err := lockIdempotencyKey(ctx, tx, claim.Key)
+		if err != nil {
+			return nil, utils.ErrorHandler(ctx, err, "Error claiming idempotency key")
+		}
+		if existing.ExpiresAt.After(claim.CreatedAt) {
+			return existing, nil
+		}
+
+		// the record expired but wasn't cleaned up yet, the key is free again
+		_, err = tx.ExecContext(ctx, "UPDATE idempotency_keys SET fingerprint = ?, completed = FALSE, status = 0, header = NULL, body = NULL, created_at = ?, expires_at = ? WHERE idem_key = ?",
+			claim.Fingerprint, claim.CreatedAt.UnixNano(), claim.ExpiresAt.UnixNano(), claim.Key)
+		if err != nil {
+			return nil, utils.ErrorHandler(ctx, err, "Error claiming idempotency key")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error committing transaction")
+	}
+	return nil, nil
+}
+
+func lockIdempotencyKey(ctx context.Context, tx *sql.Tx, key string) (*models.IdempotencyRecord, error) {
+	record := models.IdempotencyRecord{Key: key}
+	var header sql.NullString
+	var createdAt, expiresAt int64
+	err := tx.QueryRowContext(ctx, "SELECT fingerprint, completed, status, header, body, created_at, expires_at FROM idempotency_keys WHERE idem_key = ? FOR UPDATE", key).
+		Scan(&record.Fingerprint, &record.Completed, &record.Status, &header, &record.Body, &createdAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	record.CreatedAt, record.ExpiresAt = time.Unix(0, createdAt), time.Unix(0, expiresAt)
+
+	if header.Valid {
+		err = json.Unmarshal([]byte(header.String), &record.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &record, nil
+}
+
+// CompleteIdempotencyKeyDbHandler stores the response of the request holding the key, a key claimed by another
+// request in the meantime is left alone
+func CompleteIdempotencyKeyDbHandler(ctx context.Context, record models.IdempotencyRecord) error {
+	ctx, span := startSpan(ctx, "CompleteIdempotencyKeyDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error storing idempotent response")
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE idempotency_keys SET completed = TRUE, status = ?, header = ?, body = ?, expires_at = ? WHERE idem_key = ? AND fingerprint = ? AND created_at = ?",
+		record.Status, string(header), record.Body, record.ExpiresAt.UnixNano(), record.Key, record.Fingerprint, record.CreatedAt.UnixNano())
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error storing idempotent response")
+	}
+	return nil
+}
+
+// DeleteIdempotencyKeyDbHandler frees the key held by record, eg. after a failed request which may be retried
+func DeleteIdempotencyKeyDbHandler(ctx context.Context, record models.IdempotencyRecord) error {
+	ctx, span := startSpan(ctx, "DeleteIdempotencyKeyDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE idem_key = ? AND fingerprint = ? AND created_at = ? AND completed = FALSE",
+		record.Key, record.Fingerprint, record.CreatedAt.UnixNano())
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error deleting idempotency key")
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeysDbHandler removes the records expired before the given time
+func DeleteExpiredIdempotencyKeysDbHandler(ctx context.Context, before time.Time) error {
+	ctx, span := startSpan(ctx, "DeleteExpiredIdempotencyKeysDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < ?", before.UnixNano())
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error deleting expired idempotency keys")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"school-management/internal/models"
+	"school-management/pkg/utils"
+)
+
+const createImportJobsTable = `CREATE TABLE IF NOT EXISTS import_jobs (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	entity VARCHAR(32) NOT NULL,
+	file_name VARCHAR(255) NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	total_rows INT NOT NULL DEFAULT 0,
+	processed_rows INT NOT NULL DEFAULT 0,
+	created_rows INT NOT NULL DEFAULT 0,
+	updated_rows INT NOT NULL DEFAULT 0,
+	failed_rows INT NOT NULL DEFAULT 0,
+	errors LONGTEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+)`
+
+// request_id records the upload which started the job, so the job can be correlated with the request's logs
+const addImportJobsRequestID = `ALTER TABLE import_jobs ADD COLUMN request_id VARCHAR(128) NOT NULL DEFAULT ''`
+
+func CreateImportJobDbHandler(ctx context.Context, job models.ImportJob) (models.ImportJob, error) {
+	ctx, span := startSpan(ctx, "CreateImportJobDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return models.ImportJob{}, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	rowErrors, err := json.Marshal(job.Errors)
+	if err != nil {
+		return models.ImportJob{}, utils.ErrorHandler(ctx, err, "Error creating import job")
+	}
+
+	job.RequestID = utils.RequestID(ctx)
+	res, err := db.ExecContext(ctx, "INSERT INTO import_jobs (entity, file_name, status, total_rows, processed_rows, failed_rows, errors, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", job.Entity, job.FileName, job.Status, job.TotalRows, job.ProcessedRows, job.FailedRows, string(rowErrors), job.RequestID)
+	if err != nil {
+		return models.ImportJob{}, utils.ErrorHandler(ctx, err, "Error creating import job")
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return models.ImportJob{}, utils.ErrorHandler(ctx, err, "Error creating import job")
+	}
+	job.ID = int(lastID)
+	return job, nil
+}
+
+func UpdateImportJobDbHandler(ctx context.Context, job models.ImportJob) error {
+	ctx, span := startSpan(ctx, "UpdateImportJobDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	rowErrors, err := json.Marshal(job.Errors)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error updating import job")
+	}
+
+	_, err = db.ExecContext(ctx, "UPDATE import_jobs SET status = ?, processed_rows = ?, created_rows = ?, updated_rows = ?, failed_rows = ?, errors = ? WHERE id = ?", job.Status, job.ProcessedRows, job.CreatedRows, job.UpdatedRows, job.FailedRows, string(rowErrors), job.ID)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error updating import job")
+	}
+	return nil
+}
+
+func GetImportJobByIdDbHandler(ctx context.Context, id int) (models.ImportJob, error) {
+	ctx, span := startSpan(ctx, "GetImportJobByIdDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return models.ImportJob{}, utils.ErrorHandler(ctx, err, "Error connecting to DB")
+	}
+
+	var job models.ImportJob
+	var rowErrors sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT id, entity, file_name, status, total_rows, processed_rows, created_rows, updated_rows, failed_rows, errors, request_id, created_at, updated_at FROM import_jobs WHERE id = ?", id).Scan(&job.ID, &job.Entity, &job.FileName, &job.Status, &job.TotalRows, &job.ProcessedRows, &job.CreatedRows, &job.UpdatedRows, &job.FailedRows, &rowErrors, &job.RequestID, &job.CreatedAt, &job.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.ImportJob{}, &utils.NotFoundError{Entity: "Import job"}
+	} else if err != nil {
+		return models.ImportJob{}, utils.ErrorHandler(ctx, err, "Error retrieving import job")
+	}
+
+	job.Errors = []models.ImportRowError{}
+	if rowErrors.Valid && rowErrors.String != "" {
+		err = json.Unmarshal([]byte(rowErrors.String), &job.Errors)
+		if err != nil {
+			return models.ImportJob{}, utils.ErrorHandler(ctx, err, "Error retrieving import job")
+		}
+	}
+	return job, nil
+}
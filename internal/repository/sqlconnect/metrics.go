@@ -0,0 +1,43 @@
+package sqlconnect
+
+import (
+	"database/sql"
+	"school-management/pkg/metrics"
+)
+
+// the pool metrics are read from sql.DBStats on every scrape, they are 0 while the pool isn't open
+func init() {
+	gauge := func(name, help string, value func(sql.DBStats) float64) {
+		metrics.Default.NewGaugeFunc(name, help, func() float64 { return poolStat(value) })
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) {
+		metrics.Default.NewCounterFunc(name, help, func() float64 { return poolStat(value) })
+	}
+
+	gauge("db_pool_max_open_connections", "Maximum number of open connections to the database.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("db_pool_open_connections", "Established connections, in use and idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("db_pool_in_use_connections", "Connections currently in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("db_pool_idle_connections", "Idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("db_pool_wait_count_total", "Connections waited for.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("db_pool_wait_duration_seconds_total", "Time blocked waiting for a new connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("db_pool_max_idle_closed_total", "Connections closed due to max_idle_conns.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("db_pool_max_idle_time_closed_total", "Connections closed due to conn_max_idle_time.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("db_pool_max_lifetime_closed_total", "Connections closed due to conn_max_lifetime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+func poolStat(value func(sql.DBStats) float64) float64 {
+	stats, err := PoolStats()
+	if err != nil {
+		return 0
+	}
+	return value(stats)
+}
@@ -0,0 +1,120 @@
+package sqlconnect
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"school-management/pkg/utils"
+	"time"
+)
+
+// migrations are applied in order and recorded in schema_migrations, a released migration must never change.
+// students, teachers and execs predate the migrations and are expected to exist.
+var migrations = []struct {
+	Version int
+	Name    string
+	SQL     string
+}{
+	{Version: 1, Name: "create import_jobs", SQL: createImportJobsTable},
+	{Version: 2, Name: "create rate_limits", SQL: createRateLimitsTable},
+	{Version: 3, Name: "add import_jobs.request_id", SQL: addImportJobsRequestID},
+	{Version: 4, Name: "create idempotency_keys", SQL: createIdempotencyKeysTable},
+}
+
+const createSchemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migrationLock is the mysql named lock held while migrating, replicas starting together would otherwise run
+// the same ALTER TABLE and all but one of them fail to start
+const (
+	migrationLock        = "school_management_migrate"
+	migrationLockTimeout = time.Minute // when ctx has no deadline
+)
+
+// Migrate applies the pending migrations. The named lock belongs to the session, so the lock, the version read and
+// the migrations run on one connection, and the version is read after the lock: another replica may just have migrated.
+func Migrate(ctx context.Context) error {
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+	defer conn.Close()
+
+	timeout := migrationLockTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLock, max(1, int(timeout.Seconds()))).Scan(&locked)
+	if err != nil {
+		return fmt.Errorf("taking the migration lock: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for the migration lock %q held by another replica", migrationLock)
+	}
+	defer func() {
+		// a connection returned to the pool keeps its session, the lock has to be released even if ctx is done
+		_, err := conn.ExecContext(context.WithoutCancel(ctx), "DO RELEASE_LOCK(?)", migrationLock)
+		if err != nil {
+			utils.Logger(ctx).Error("error releasing the migration lock", "error", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, createSchemaMigrationsTable)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		// mysql commits DDL implicitly, so there is no transaction around a migration and its record
+		_, err = conn.ExecContext(ctx, migration.SQL)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		_, err = conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name)
+		if err != nil {
+			return fmt.Errorf("recording migration %d: %w", migration.Version, err)
+		}
+	}
+	return nil
+}
+
+// CheckMigrations fails if the database schema is behind the migrations of this build
+func CheckMigrations(ctx context.Context) error {
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+	current, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].Version; current < latest {
+		return fmt.Errorf("schema version %d is behind %d", current, latest)
+	}
+	return nil
+}
+
+func schemaVersion(ctx context.Context, q queryRower) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,86 @@
+package sqlconnect
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// useFakePool swaps the shared pool for a fake one until the test ends
+func useFakePool(t *testing.T, server *fakeServer) {
+	db := newFakeDB(server)
+	poolMu.Lock()
+	previous := pool
+	pool = db
+	poolMu.Unlock()
+	t.Cleanup(func() {
+		poolMu.Lock()
+		pool = previous
+		poolMu.Unlock()
+		db.Close()
+	})
+}
+
+func TestMigrateReadsTheVersionUnderTheLock(t *testing.T) {
+	// another replica already migrated while this one waited for the lock
+	server := &fakeServer{lockFree: true, version: int64(migrations[len(migrations)-1].Version)}
+	useFakePool(t, server)
+
+	if err := Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	lock, version, release := -1, -1, -1
+	for i, query := range server.executed {
+		switch {
+		case strings.Contains(query, "GET_LOCK"):
+			lock = i
+		case strings.Contains(query, "MAX(version)"):
+			version = i
+		case strings.Contains(query, "RELEASE_LOCK"):
+			release = i
+		case strings.Contains(query, "INSERT INTO schema_migrations"):
+			t.Errorf("migration recorded again although the schema is current: %v", server.executed)
+		}
+	}
+	if lock < 0 || !(lock < version && version < release) {
+		t.Errorf("statements = %v, want GET_LOCK, then the version read, then RELEASE_LOCK", server.executed)
+	}
+}
+
+func TestMigrateAppliesPendingMigrations(t *testing.T) {
+	server := &fakeServer{lockFree: true}
+	useFakePool(t, server)
+
+	if err := Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	recorded := 0
+	for _, query := range server.executed {
+		if strings.Contains(query, "INSERT INTO schema_migrations") {
+			recorded++
+		}
+	}
+	if recorded != len(migrations) {
+		t.Errorf("recorded %d migrations, want %d", recorded, len(migrations))
+	}
+	if last := server.executed[len(server.executed)-1]; !strings.Contains(last, "RELEASE_LOCK") {
+		t.Errorf("last statement = %q, want the lock released", last)
+	}
+}
+
+func TestMigrateFailsWhenTheLockIsHeld(t *testing.T) {
+	server := &fakeServer{}
+	useFakePool(t, server)
+
+	err := Migrate(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "migration lock") {
+		t.Fatalf("Migrate() error = %v, want the lock timeout", err)
+	}
+	for _, query := range server.executed {
+		if strings.Contains(query, "schema_migrations") {
+			t.Errorf("ran %q without holding the lock", query)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package sqlconnect
+
+import (
+	"context"
+	"school-management/internal/models"
+	"school-management/pkg/utils"
+	"time"
+)
+
+// times are stored as unix nanoseconds, so the dsn doesn't need parseTime
+const createRateLimitsTable = `CREATE TABLE IF NOT EXISTS rate_limits (
+	rl_key CHAR(64) PRIMARY KEY,
+	tokens DOUBLE NOT NULL DEFAULT 0,
+	last_refill BIGINT NOT NULL DEFAULT 0,
+	window_start BIGINT NOT NULL DEFAULT 0,
+	prev_count INT NOT NULL DEFAULT 0,
+	curr_count INT NOT NULL DEFAULT 0,
+	last_seen BIGINT NOT NULL DEFAULT 0,
+	INDEX idx_rate_limits_last_seen (last_seen)
+)`
+
+// UpdateRateLimitDbHandler locks the state of key (a new state is passed with isNew), lets update change it and stores it.
+// The row lock makes concurrent updates of the same key from all replicas atomic.
+func UpdateRateLimitDbHandler(ctx context.Context, key string, update func(state *models.RateLimitState, isNew bool)) error {
+	ctx, span := startSpan(ctx, "UpdateRateLimitDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT IGNORE INTO rate_limits (rl_key) VALUES (?)", key)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error updating rate limit")
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error updating rate limit")
+	}
+
+	var state models.RateLimitState
+	var lastRefill, windowStart, lastSeen int64
+	err = tx.QueryRowContext(ctx, "SELECT tokens, last_refill, window_start, prev_count, curr_count, last_seen FROM rate_limits WHERE rl_key = ? FOR UPDATE", key).Scan(&state.Tokens, &lastRefill, &windowStart, &state.PrevCount, &state.CurrCount, &lastSeen)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error updating rate limit")
+	}
+	state.LastRefill, state.WindowStart, state.LastSeen = time.Unix(0, lastRefill), time.Unix(0, windowStart), time.Unix(0, lastSeen)
+
+	update(&state, inserted == 1)
+
+	_, err = tx.ExecContext(ctx, "UPDATE rate_limits SET tokens = ?, last_refill = ?, window_start = ?, prev_count = ?, curr_count = ?, last_seen = ? WHERE rl_key = ?",
+		state.Tokens, state.LastRefill.UnixNano(), state.WindowStart.UnixNano(), state.PrevCount, state.CurrCount, state.LastSeen.UnixNano(), key)
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error updating rate limit")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error committing transaction")
+	}
+	return nil
+}
+
+// DeleteIdleRateLimitsDbHandler removes the states last seen before the given time
+func DeleteIdleRateLimitsDbHandler(ctx context.Context, before time.Time) error {
+	ctx, span := startSpan(ctx, "DeleteIdleRateLimitsDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM rate_limits WHERE last_seen < ?", before.UnixNano())
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error deleting idle rate limits")
+	}
+	return nil
+}
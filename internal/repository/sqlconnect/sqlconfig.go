@@ -1,22 +1,84 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
-	"log"
-	"os"
+	"errors"
+	"log/slog"
+	"school-management/internal/config"
+	"sync"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
+var (
+	poolMu sync.RWMutex
+	pool   *sql.DB
+)
+
+// OpenPool opens the connection pool shared by all db handlers
+func OpenPool(cfg config.Database) error {
+	slog.Info("connecting to MariaDB")
+
+	dsn, err := mysql.ParseDSN(cfg.DSN)
+	if err != nil {
+		return err
+	}
+	connector, err := mysql.NewConnector(dsn)
+	if err != nil {
+		return err
+	}
+	// statements are traced and the ones run for a request carry its id as a comment, see instrumentedConnector
+	db := sql.OpenDB(instrumentedConnector{Connector: connector, dbName: dsn.DBName, addr: dsn.Addr})
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	poolMu.Lock()
+	pool = db
+	poolMu.Unlock()
+
+	slog.Info("connected to MariaDB")
+	return nil
+}
+
+// ConnectDB returns the shared pool, callers must not close it
 func ConnectDB() (*sql.DB, error) {
-	log.Println("Trying to connet to MariaDB...")
+	poolMu.RLock()
+	defer poolMu.RUnlock()
+	if pool == nil {
+		return nil, errors.New("database pool is not open")
+	}
+	return pool, nil
+}
 
-	connectionString := os.Getenv("CONNECTION_STRING")
+// PingDB checks that the database is reachable
+func PingDB(ctx context.Context) error {
+	db, err := ConnectDB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
 
-	db, err := sql.Open("mysql", connectionString)
+// PoolStats reports the connection pool statistics
+func PoolStats() (sql.DBStats, error) {
+	db, err := ConnectDB()
 	if err != nil {
-		return nil, err
+		return sql.DBStats{}, err
+	}
+	return db.Stats(), nil
+}
+
+// ClosePool closes the shared pool, waiting for running queries to finish
+func ClosePool() error {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if pool == nil {
+		return nil
 	}
-	log.Println("Connected to MariaDB.")
-	return db, nil
+	err := pool.Close()
+	pool = nil
+	return err
 }
@@ -1,180 +1,221 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
 	"reflect"
 	"school-management/internal/models"
 	"school-management/pkg/utils"
 	"strconv"
-	"strings"
 )
 
-func GetStudentByIdDbHandler(id int) (models.Student, error) {
+func GetStudentByIdDbHandler(ctx context.Context, id int) (models.Student, error) {
+	ctx, span := startSpan(ctx, "GetStudentByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error connecting to DB")
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error connecting to DB")
 	}
-	defer db.Close()
 
 	var student models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
 
 	if err == sql.ErrNoRows {
-		return models.Student{}, utils.ErrorHandler(err, "Student Not found")
+		return models.Student{}, &utils.NotFoundError{Entity: "Student"}
 	} else if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error retrieving Student")
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error retrieving Student")
 	}
 	return student, nil
 }
 
 func GetStudentsDbHandler(students []models.Student, r *http.Request) ([]models.Student, error) {
+	err := StreamStudentsDbHandler(r, func(student models.Student) error {
+		students = append(students, student)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return students, nil
+}
+
+// StreamStudentsDbHandler runs the filtered and sorted student query and calls onRow for every row straight from the cursor,
+// so large exports never hold the whole table in memory. An error returned by onRow stops the iteration.
+func StreamStudentsDbHandler(r *http.Request, onRow func(student models.Student) error) error {
+	ctx, span := startSpan(r.Context(), "StreamStudentsDbHandler")
+	defer span.End()
 
 	query := "SELECT id, first_name, last_name, email, class FROM students WHERE 1 = 1"
 	var args []interface{}
 
 	query, args = utils.AddFilters(r, query, args)
 
-	// also handling - Students/?sortby=name:asc&sortby=class:desc
+	// also handling - students/?sortby=name:asc&sortby=class:desc
 	query = utils.AddSorting(r, query)
 
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error querying DB")
+		return utils.ErrorHandler(ctx, err, "Error querying DB")
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var student models.Student
-		err = rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Class, &student.Email)
+		err = rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Email, &student.Class)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "Error scaning row from db")
+			return utils.ErrorHandler(ctx, err, "Error scaning row from db")
+		}
+		err = onRow(student)
+		if err != nil {
+			return err
 		}
-		students = append(students, student)
 	}
-	return students, nil
+	err = rows.Err()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "rows error")
+	}
+	return nil
 }
+func AddStudentsDbHandler(ctx context.Context, addedStudents []models.Student, newStudents []models.Student) ([]models.Student, error) {
+	ctx, span := startSpan(ctx, "AddStudentsDbHandler")
+	defer span.End()
 
-func AddStudentsDbHandler(addedStudents []models.Student, newStudents []models.Student) ([]models.Student, error) {
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	// stmt, err := db.Prepare("INSERT INTO students (first_name, last_name, email, class) VALUES(?,?,?,?,?)")
-	stmt, err := db.Prepare(utils.GenerateInsertQuery("Students", models.Student{}))
+	rows := make([][]interface{}, len(newStudents))
+	for i, newStudent := range newStudents {
+		rows[i] = utils.GetStructValues(newStudent)
+	}
+
+	// all or nothing - a single failing student rolls back the whole batch
+	ids, err := insertRowsAtomic(ctx, db, "students", "student", models.Student{}, rows)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error updating Student")
+		return nil, err
 	}
-	defer stmt.Close()
 
-	for _, newStudent := range newStudents {
-		values := utils.GetStructValues(newStudent)
-		// res, err := stmt.Exec(newStudent.FirstName, newStudent.LastName, newStudent.Email, newStudent.Class)
-		res, err := stmt.Exec(values...)
-		if err != nil {
-			if strings.Contains(err.Error(), "duplicate") {
-				return nil, utils.ErrorHandler(err, "")
-			}
-			return nil, utils.ErrorHandler(err, "Error updating Student")
-		}
-		lastID, err := res.LastInsertId()
-		if err != nil {
-			return nil, utils.ErrorHandler(err, "Error updating Student")
-		}
-		newStudent.ID = int(lastID)
+	for i, newStudent := range newStudents {
+		newStudent.ID = ids[i]
 		addedStudents = append(addedStudents, newStudent)
 	}
 	return addedStudents, nil
 }
 
-func UpdateStudentByIdDbHandle(id int, updatedStudent models.Student) (models.Student, error) {
+// AddStudentsPartialDbHandler inserts each student independently and reports the outcome per student
+func AddStudentsPartialDbHandler(ctx context.Context, newStudents []models.Student) ([]models.BulkItemResult, error) {
+	ctx, span := startSpan(ctx, "AddStudentsPartialDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error Connecting to DB")
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	rows := make([][]interface{}, len(newStudents))
+	for i, newStudent := range newStudents {
+		rows[i] = utils.GetStructValues(newStudent)
+	}
+	return insertRowsPartial(ctx, db, "students", "student", models.Student{}, rows)
+}
+func UpdateStudentByIdDbHandle(ctx context.Context, id int, updatedStudent models.Student) (models.Student, error) {
+	ctx, span := startSpan(ctx, "UpdateStudentByIdDbHandle")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
 	if err == sql.ErrNoRows {
-		return models.Student{}, utils.ErrorHandler(err, "Student not found")
+		return models.Student{}, &utils.NotFoundError{Entity: "Student"}
 	} else if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error updating Student")
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error updating Student")
 	}
 
 	updatedStudent.ID = existingStudent.ID
-	_, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", updatedStudent.FirstName, updatedStudent.LastName, updatedStudent.Email, updatedStudent.Class, updatedStudent.ID)
+	_, err = db.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", updatedStudent.FirstName, updatedStudent.LastName, updatedStudent.Email, updatedStudent.Class, updatedStudent.ID)
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error updating Student")
+		if conflictErr := handleDuplicateKeyError(ctx, db, err, "students", "student", models.Student{}); conflictErr != nil {
+			return models.Student{}, conflictErr
+		}
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error updating Student")
 	}
 	return updatedStudent, nil
 }
 
-func DeleteStudentByIdDbHandler(id int) error {
+func DeleteStudentByIdDbHandler(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteStudentByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM students WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, "DELETE FROM students WHERE id = ?", id)
 	if err != nil {
-		return utils.ErrorHandler(err, "Error deleting Student")
+		return utils.ErrorHandler(ctx, err, "Error deleting Student")
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error deleting Student")
+		return utils.ErrorHandler(ctx, err, "Error deleting Student")
 	}
 
 	if rowsAffected == 0 {
-		return utils.ErrorHandler(err, "Student not found")
+		return &utils.NotFoundError{Entity: "Student"}
 	}
 	return nil
 }
 
-func PatchStudentsDbHandler(updates []map[string]interface{}) error {
+func PatchStudentsDbHandler(ctx context.Context, updates []map[string]interface{}) error {
+	ctx, span := startSpan(ctx, "PatchStudentsDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
 	// transactions are used for commands which should either execute all or all fail
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "Error starting transaction")
+		return utils.ErrorHandler(ctx, err, "Error starting transaction")
 	}
 
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Error retrieving id")
+			return utils.ErrorHandler(ctx, err, "Error retrieving id")
 		}
 
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Invalid Student Id")
+			return utils.ErrorHandler(ctx, err, "Invalid Student Id")
 		}
 
 		var studentFromDb models.Student
-		err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&studentFromDb.ID, &studentFromDb.FirstName, &studentFromDb.LastName, &studentFromDb.Email, &studentFromDb.Class)
+		err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE id = ?", id).Scan(&studentFromDb.ID, &studentFromDb.FirstName, &studentFromDb.LastName, &studentFromDb.Email, &studentFromDb.Class)
 
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Student not found")
+				return &utils.NotFoundError{Entity: "Student"}
 			}
-			return utils.ErrorHandler(err, "Error updating Students")
+			return utils.ErrorHandler(ctx, err, "Error updating Students")
 		}
 
 		studentVal := reflect.ValueOf(&studentFromDb).Elem()
@@ -195,17 +236,21 @@ func PatchStudentsDbHandler(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							return utils.ErrorHandler(err, "Error getting filed value")
+							return utils.ErrorHandler(ctx, err, "Error getting filed value")
 						}
 					}
 					break
 				}
 			}
 		}
-		_, err = tx.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", studentFromDb.FirstName, studentFromDb.LastName, studentFromDb.Email, studentFromDb.Class, studentFromDb.ID)
+		_, err = tx.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", studentFromDb.FirstName, studentFromDb.LastName, studentFromDb.Email, studentFromDb.Class, studentFromDb.ID)
 		if err != nil {
+			if conflictErr := handleDuplicateKeyError(ctx, tx, err, "students", "student", models.Student{}); conflictErr != nil {
+				tx.Rollback()
+				return conflictErr
+			}
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Error updating Students")
+			return utils.ErrorHandler(ctx, err, "Error updating Students")
 		}
 	}
 
@@ -213,86 +258,97 @@ func PatchStudentsDbHandler(updates []map[string]interface{}) error {
 	err = tx.Commit()
 	if err != nil {
 		tx.Rollback()
-		return utils.ErrorHandler(err, "Error commiting transaction")
+		return utils.ErrorHandler(ctx, err, "Error commiting transaction")
 	}
 	return nil
 }
 
-func PatchStudentByIdDbHandler(id int, updates map[string]string) (models.Student, error) {
+// PatchStudentByIdDbHandler locks the student, lets patch change it and stores it, all or nothing
+func PatchStudentByIdDbHandler(ctx context.Context, id int, patch func(student *models.Student) error) (models.Student, error) {
+	ctx, span := startSpan(ctx, "PatchStudentByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error Connecting to DB")
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
+
+	// the row stays locked until the patched student is stored, so concurrent patches apply one after the other
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error starting transaction")
+	}
+	defer tx.Rollback()
 
 	var existingStudent models.Student
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class FROM students where id = ?", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class FROM students WHERE id = ? FOR UPDATE", id).Scan(&existingStudent.ID, &existingStudent.FirstName, &existingStudent.LastName, &existingStudent.Email, &existingStudent.Class)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Student{}, utils.ErrorHandler(err, "Student not found")
+			return models.Student{}, &utils.NotFoundError{Entity: "Student"}
 		}
-		return models.Student{}, utils.ErrorHandler(err, "Error updating Student")
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error updating Student")
 	}
 
-	studentVal := reflect.ValueOf(&existingStudent).Elem()
-	studentType := studentVal.Type()
+	// an invalid patch is the client's fault, its error is returned as it is
+	err = patch(&existingStudent)
+	if err != nil {
+		return models.Student{}, err
+	}
 
-	for k, v := range updates {
-		for i := 0; i < studentVal.NumField(); i++ {
-			field := studentType.Field(i)
-			if field.Tag.Get("json") == k+",omitempty" {
-				if studentVal.Field(i).CanSet() {
-					fieldVal := studentVal.Field(i)
-					fieldVal.Set(reflect.ValueOf(v).Convert(studentVal.Field(i).Type()))
-				}
-			}
+	_, err = tx.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", existingStudent.FirstName, existingStudent.LastName, existingStudent.Email, existingStudent.Class, existingStudent.ID)
+	if err != nil {
+		if conflictErr := handleDuplicateKeyError(ctx, tx, err, "students", "student", models.Student{}); conflictErr != nil {
+			return models.Student{}, conflictErr
 		}
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error updating Student")
 	}
 
-	_, err = db.Exec("UPDATE students SET first_name = ?, last_name = ?, email = ?, class = ? WHERE id = ?", existingStudent.FirstName, existingStudent.LastName, existingStudent.Email, existingStudent.Class, existingStudent.ID)
+	err = tx.Commit()
 	if err != nil {
-		return models.Student{}, utils.ErrorHandler(err, "Error updating Student")
+		return models.Student{}, utils.ErrorHandler(ctx, err, "Error committing transaction")
 	}
 	return existingStudent, nil
 }
 
-func DeleteStudentsDbHandler(ids []int) ([]int, error) {
+func DeleteStudentsDbHandler(ctx context.Context, ids []int) ([]int, error) {
+	ctx, span := startSpan(ctx, "DeleteStudentsDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error starting transaction")
+		return nil, utils.ErrorHandler(ctx, err, "Error starting transaction")
 	}
 
-	stmt, err := tx.Prepare("DELETE FROM students WHERE id = ?")
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM students WHERE id = ?")
 	if err != nil {
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "Error preparing query")
+		return nil, utils.ErrorHandler(ctx, err, "Error preparing query")
 	}
 	defer stmt.Close()
 
 	deletedIds := []int{}
 
 	for _, id := range ids {
-		result, err := stmt.Exec(id)
+		result, err := stmt.ExecContext(ctx, id)
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "Error executing query")
+			return nil, utils.ErrorHandler(ctx, err, "Error executing query")
 		}
 
 		rowsAffected, err := result.RowsAffected()
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "Error getting affected rows")
+			return nil, utils.ErrorHandler(ctx, err, "Error getting affected rows")
 		}
 
 		if rowsAffected == 0 {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "Student not found")
+			return nil, &utils.NotFoundError{Entity: "Student"}
 		} else if rowsAffected > 0 {
 			deletedIds = append(deletedIds, id)
 		}
@@ -300,13 +356,69 @@ func DeleteStudentsDbHandler(ids []int) ([]int, error) {
 
 	if len(deletedIds) != len(ids) {
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "Error deleting Students")
+		return nil, utils.ErrorHandler(ctx, err, "Error deleting Students")
 	}
 
 	err = tx.Commit()
 	if err != nil {
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "Error commiting deleted changes")
+		return nil, utils.ErrorHandler(ctx, err, "Error commiting deleted changes")
 	}
 	return deletedIds, nil
 }
+
+// ImportStudentsDbHandler upserts students by email (existing students are updated, new ones inserted).
+// onRow is called after every student with the id, whether it was created and the error for that student.
+func ImportStudentsDbHandler(ctx context.Context, students []models.Student, onRow func(index int, id int, created bool, err error)) error {
+	ctx, span := startSpan(ctx, "ImportStudentsDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	insertStmt, err := db.PrepareContext(ctx, utils.GenerateInsertQuery("students", models.Student{}))
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error importing students")
+	}
+	defer insertStmt.Close()
+
+	for i, student := range students {
+		if ctx.Err() != nil {
+			return utils.ErrorHandler(ctx, ctx.Err(), "Import of students interrupted")
+		}
+
+		var existingID int
+		err = db.QueryRowContext(ctx, "SELECT id FROM students WHERE email = ?", student.Email).Scan(&existingID)
+		if err == sql.ErrNoRows {
+			res, err := insertStmt.ExecContext(ctx, utils.GetStructValues(student)...)
+			if err != nil {
+				if conflictErr := handleDuplicateKeyError(ctx, db, err, "students", "student", models.Student{}); conflictErr != nil {
+					onRow(i, 0, false, conflictErr)
+					continue
+				}
+				onRow(i, 0, false, utils.ErrorHandler(ctx, err, "Error adding student"))
+				continue
+			}
+			lastID, err := res.LastInsertId()
+			if err != nil {
+				onRow(i, 0, false, utils.ErrorHandler(ctx, err, "Error adding student"))
+				continue
+			}
+			onRow(i, int(lastID), true, nil)
+			continue
+		} else if err != nil {
+			onRow(i, 0, false, utils.ErrorHandler(ctx, err, "Error retrieving student"))
+			continue
+		}
+
+		_, err = db.ExecContext(ctx, "UPDATE students SET first_name = ?, last_name = ?, class = ? WHERE id = ?", student.FirstName, student.LastName, student.Class, existingID)
+		if err != nil {
+			onRow(i, existingID, false, utils.ErrorHandler(ctx, err, "Error updating student"))
+			continue
+		}
+		onRow(i, existingID, false, nil)
+	}
+	return nil
+}
@@ -1,34 +1,51 @@
 package sqlconnect
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
 	"reflect"
 	"school-management/internal/models"
 	"school-management/pkg/utils"
 	"strconv"
-	"strings"
 )
 
-func GetTeacherByIdDbHandler(id int) (models.Teacher, error) {
+func GetTeacherByIdDbHandler(ctx context.Context, id int) (models.Teacher, error) {
+	ctx, span := startSpan(ctx, "GetTeacherByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error connecting to DB")
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error connecting to DB")
 	}
-	defer db.Close()
 
 	var teacher models.Teacher
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
 
 	if err == sql.ErrNoRows {
-		return models.Teacher{}, utils.ErrorHandler(err, "Teacher Not found")
+		return models.Teacher{}, &utils.NotFoundError{Entity: "Teacher"}
 	} else if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error retrieving teacher")
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error retrieving teacher")
 	}
 	return teacher, nil
 }
 
 func GetTeachersDbHandler(teachers []models.Teacher, r *http.Request) ([]models.Teacher, error) {
+	err := StreamTeachersDbHandler(r, func(teacher models.Teacher) error {
+		teachers = append(teachers, teacher)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return teachers, nil
+}
+
+// StreamTeachersDbHandler runs the filtered and sorted teacher query and calls onRow for every row straight from the cursor,
+// so large exports never hold the whole table in memory. An error returned by onRow stops the iteration.
+func StreamTeachersDbHandler(r *http.Request, onRow func(teacher models.Teacher) error) error {
+	ctx, span := startSpan(r.Context(), "StreamTeachersDbHandler")
+	defer span.End()
 
 	query := "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE 1 = 1"
 	var args []interface{}
@@ -40,141 +57,165 @@ func GetTeachersDbHandler(teachers []models.Teacher, r *http.Request) ([]models.
 
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error querying DB")
+		return utils.ErrorHandler(ctx, err, "Error querying DB")
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var teacher models.Teacher
-		err = rows.Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Class, &teacher.Email, &teacher.Subject)
+		err = rows.Scan(&teacher.ID, &teacher.FirstName, &teacher.LastName, &teacher.Email, &teacher.Class, &teacher.Subject)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "Error scaning row from db")
+			return utils.ErrorHandler(ctx, err, "Error scaning row from db")
+		}
+		err = onRow(teacher)
+		if err != nil {
+			return err
 		}
-		teachers = append(teachers, teacher)
 	}
-	return teachers, nil
+	err = rows.Err()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "rows error")
+	}
+	return nil
 }
+func AddTeachersDbHandler(ctx context.Context, addedTeachers []models.Teacher, newTeachers []models.Teacher) ([]models.Teacher, error) {
+	ctx, span := startSpan(ctx, "AddTeachersDbHandler")
+	defer span.End()
 
-func AddTeachersDbHandler(addedTeachers []models.Teacher, newTeachers []models.Teacher) ([]models.Teacher, error) {
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	// stmt, err := db.Prepare("INSERT INTO teachers (first_name, last_name, email, class, subject) VALUES(?,?,?,?,?)")
-	stmt, err := db.Prepare(utils.GenerateInsertQuery("teachers", models.Teacher{}))
+	rows := make([][]interface{}, len(newTeachers))
+	for i, newTeacher := range newTeachers {
+		rows[i] = utils.GetStructValues(newTeacher)
+	}
+
+	// all or nothing - a single failing teacher rolls back the whole batch
+	ids, err := insertRowsAtomic(ctx, db, "teachers", "teacher", models.Teacher{}, rows)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error updating teacher")
+		return nil, err
 	}
-	defer stmt.Close()
 
-	for _, newTeacher := range newTeachers {
-		values := utils.GetStructValues(newTeacher)
-		// res, err := stmt.Exec(newTeacher.FirstName, newTeacher.LastName, newTeacher.Email, newTeacher.Class, newTeacher.Subject)
-		res, err := stmt.Exec(values...)
-		if err != nil {
-			if strings.Contains(err.Error(), "duplicate") {
-				return nil, utils.ErrorHandler(err, "")
-			}
-			return nil, utils.ErrorHandler(err, "Error updating teacher")
-		}
-		lastID, err := res.LastInsertId()
-		if err != nil {
-			return nil, utils.ErrorHandler(err, "Error updating teacher")
-		}
-		newTeacher.ID = int(lastID)
+	for i, newTeacher := range newTeachers {
+		newTeacher.ID = ids[i]
 		addedTeachers = append(addedTeachers, newTeacher)
 	}
 	return addedTeachers, nil
 }
 
-func UpdateTeacherByIdDbHandle(id int, updatedTeacher models.Teacher) (models.Teacher, error) {
+// AddTeachersPartialDbHandler inserts each teacher independently and reports the outcome per teacher
+func AddTeachersPartialDbHandler(ctx context.Context, newTeachers []models.Teacher) ([]models.BulkItemResult, error) {
+	ctx, span := startSpan(ctx, "AddTeachersPartialDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	rows := make([][]interface{}, len(newTeachers))
+	for i, newTeacher := range newTeachers {
+		rows[i] = utils.GetStructValues(newTeacher)
+	}
+	return insertRowsPartial(ctx, db, "teachers", "teacher", models.Teacher{}, rows)
+}
+func UpdateTeacherByIdDbHandle(ctx context.Context, id int, updatedTeacher models.Teacher) (models.Teacher, error) {
+	ctx, span := startSpan(ctx, "UpdateTeacherByIdDbHandle")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error Connecting to DB")
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
 	var existingTeacher models.Teacher
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
+	err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
 	if err == sql.ErrNoRows {
-		return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found")
+		return models.Teacher{}, &utils.NotFoundError{Entity: "Teacher"}
 	} else if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error updating teacher")
 	}
 
 	updatedTeacher.ID = existingTeacher.ID
-	_, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", updatedTeacher.FirstName, updatedTeacher.LastName, updatedTeacher.Email, updatedTeacher.Class, updatedTeacher.Subject, updatedTeacher.ID)
+	_, err = db.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", updatedTeacher.FirstName, updatedTeacher.LastName, updatedTeacher.Email, updatedTeacher.Class, updatedTeacher.Subject, updatedTeacher.ID)
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
+		if conflictErr := handleDuplicateKeyError(ctx, db, err, "teachers", "teacher", models.Teacher{}); conflictErr != nil {
+			return models.Teacher{}, conflictErr
+		}
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error updating teacher")
 	}
 	return updatedTeacher, nil
 }
 
-func DeleteTeacherByIdDbHandler(id int) error {
+func DeleteTeacherByIdDbHandler(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "DeleteTeacherByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	result, err := db.Exec("DELETE FROM teachers WHERE id = ?", id)
+	result, err := db.ExecContext(ctx, "DELETE FROM teachers WHERE id = ?", id)
 	if err != nil {
-		return utils.ErrorHandler(err, "Error deleting teacher")
+		return utils.ErrorHandler(ctx, err, "Error deleting teacher")
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error deleting teacher")
+		return utils.ErrorHandler(ctx, err, "Error deleting teacher")
 	}
 
 	if rowsAffected == 0 {
-		return utils.ErrorHandler(err, "Teacher not found")
+		return &utils.NotFoundError{Entity: "Teacher"}
 	}
 	return nil
 }
 
-func PatchTeachersDbHandler(updates []map[string]interface{}) error {
+func PatchTeachersDbHandler(ctx context.Context, updates []map[string]interface{}) error {
+	ctx, span := startSpan(ctx, "PatchTeachersDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return utils.ErrorHandler(err, "Error Connecting to DB")
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
 	// transactions are used for commands which should either execute all or all fail
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return utils.ErrorHandler(err, "Error starting transaction")
+		return utils.ErrorHandler(ctx, err, "Error starting transaction")
 	}
 
 	for _, update := range updates {
 		idStr, ok := update["id"].(string)
 		if !ok {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Error retrieving id")
+			return utils.ErrorHandler(ctx, err, "Error retrieving id")
 		}
 
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Invalid teacher Id")
+			return utils.ErrorHandler(ctx, err, "Invalid teacher Id")
 		}
 
 		var teacherFromDb models.Teacher
-		err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&teacherFromDb.ID, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Email, &teacherFromDb.Class, &teacherFromDb.Subject)
+		err = db.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ?", id).Scan(&teacherFromDb.ID, &teacherFromDb.FirstName, &teacherFromDb.LastName, &teacherFromDb.Email, &teacherFromDb.Class, &teacherFromDb.Subject)
 
 		if err != nil {
 			tx.Rollback()
 			if err == sql.ErrNoRows {
-				return utils.ErrorHandler(err, "Teacher not found")
+				return &utils.NotFoundError{Entity: "Teacher"}
 			}
-			return utils.ErrorHandler(err, "Error updating teachers")
+			return utils.ErrorHandler(ctx, err, "Error updating teachers")
 		}
 
 		teacherVal := reflect.ValueOf(&teacherFromDb).Elem()
@@ -195,17 +236,21 @@ func PatchTeachersDbHandler(updates []map[string]interface{}) error {
 							fieldVal.Set(val.Convert(fieldVal.Type()))
 						} else {
 							tx.Rollback()
-							return utils.ErrorHandler(err, "Error getting filed value")
+							return utils.ErrorHandler(ctx, err, "Error getting filed value")
 						}
 					}
 					break
 				}
 			}
 		}
-		_, err = tx.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Email, teacherFromDb.Class, teacherFromDb.Subject, teacherFromDb.ID)
+		_, err = tx.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", teacherFromDb.FirstName, teacherFromDb.LastName, teacherFromDb.Email, teacherFromDb.Class, teacherFromDb.Subject, teacherFromDb.ID)
 		if err != nil {
+			if conflictErr := handleDuplicateKeyError(ctx, tx, err, "teachers", "teacher", models.Teacher{}); conflictErr != nil {
+				tx.Rollback()
+				return conflictErr
+			}
 			tx.Rollback()
-			return utils.ErrorHandler(err, "Error updating teachers")
+			return utils.ErrorHandler(ctx, err, "Error updating teachers")
 		}
 	}
 
@@ -213,86 +258,97 @@ func PatchTeachersDbHandler(updates []map[string]interface{}) error {
 	err = tx.Commit()
 	if err != nil {
 		tx.Rollback()
-		return utils.ErrorHandler(err, "Error commiting transaction")
+		return utils.ErrorHandler(ctx, err, "Error commiting transaction")
 	}
 	return nil
 }
 
-func PatchTeacherByIdDbHandler(id int, updates map[string]string) (models.Teacher, error) {
+// PatchTeacherByIdDbHandler locks the teacher, lets patch change it and stores it, all or nothing
+func PatchTeacherByIdDbHandler(ctx context.Context, id int, patch func(teacher *models.Teacher) error) (models.Teacher, error) {
+	ctx, span := startSpan(ctx, "PatchTeacherByIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error Connecting to DB")
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
+
+	// the row stays locked until the patched teacher is stored, so concurrent patches apply one after the other
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error starting transaction")
+	}
+	defer tx.Rollback()
 
 	var existingTeacher models.Teacher
-	err = db.QueryRow("SELECT id, first_name, last_name, email, class, subject FROM teachers where id = ?", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
+	err = tx.QueryRowContext(ctx, "SELECT id, first_name, last_name, email, class, subject FROM teachers WHERE id = ? FOR UPDATE", id).Scan(&existingTeacher.ID, &existingTeacher.FirstName, &existingTeacher.LastName, &existingTeacher.Email, &existingTeacher.Class, &existingTeacher.Subject)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return models.Teacher{}, utils.ErrorHandler(err, "Teacher not found")
+			return models.Teacher{}, &utils.NotFoundError{Entity: "Teacher"}
 		}
-		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error updating teacher")
 	}
 
-	teacherVal := reflect.ValueOf(&existingTeacher).Elem()
-	teacherType := teacherVal.Type()
+	// an invalid patch is the client's fault, its error is returned as it is
+	err = patch(&existingTeacher)
+	if err != nil {
+		return models.Teacher{}, err
+	}
 
-	for k, v := range updates {
-		for i := 0; i < teacherVal.NumField(); i++ {
-			field := teacherType.Field(i)
-			if field.Tag.Get("json") == k+",omitempty" {
-				if teacherVal.Field(i).CanSet() {
-					fieldVal := teacherVal.Field(i)
-					fieldVal.Set(reflect.ValueOf(v).Convert(teacherVal.Field(i).Type()))
-				}
-			}
+	_, err = tx.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", existingTeacher.FirstName, existingTeacher.LastName, existingTeacher.Email, existingTeacher.Class, existingTeacher.Subject, existingTeacher.ID)
+	if err != nil {
+		if conflictErr := handleDuplicateKeyError(ctx, tx, err, "teachers", "teacher", models.Teacher{}); conflictErr != nil {
+			return models.Teacher{}, conflictErr
 		}
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error updating teacher")
 	}
 
-	_, err = db.Exec("UPDATE teachers SET first_name = ?, last_name = ?, email = ?, class = ?, subject = ? WHERE id = ?", existingTeacher.FirstName, existingTeacher.LastName, existingTeacher.Email, existingTeacher.Class, existingTeacher.Subject, existingTeacher.ID)
+	err = tx.Commit()
 	if err != nil {
-		return models.Teacher{}, utils.ErrorHandler(err, "Error updating teacher")
+		return models.Teacher{}, utils.ErrorHandler(ctx, err, "Error committing transaction")
 	}
 	return existingTeacher, nil
 }
 
-func DeleteTeachersDbHandler(ids []int) ([]int, error) {
+func DeleteTeachersDbHandler(ctx context.Context, ids []int) ([]int, error) {
+	ctx, span := startSpan(ctx, "DeleteTeachersDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error Connecting to DB")
+		return nil, utils.ErrorHandler(ctx, err, "Error Connecting to DB")
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "Error starting transaction")
+		return nil, utils.ErrorHandler(ctx, err, "Error starting transaction")
 	}
 
-	stmt, err := tx.Prepare("DELETE FROM teachers WHERE id = ?")
+	stmt, err := tx.PrepareContext(ctx, "DELETE FROM teachers WHERE id = ?")
 	if err != nil {
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "Error preparing query")
+		return nil, utils.ErrorHandler(ctx, err, "Error preparing query")
 	}
 	defer stmt.Close()
 
 	deletedIds := []int{}
 
 	for _, id := range ids {
-		result, err := stmt.Exec(id)
+		result, err := stmt.ExecContext(ctx, id)
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "Error executing query")
+			return nil, utils.ErrorHandler(ctx, err, "Error executing query")
 		}
 
 		rowsAffected, err := result.RowsAffected()
 		if err != nil {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "Error getting affected rows")
+			return nil, utils.ErrorHandler(ctx, err, "Error getting affected rows")
 		}
 
 		if rowsAffected == 0 {
 			tx.Rollback()
-			return nil, utils.ErrorHandler(err, "Teacher not found")
+			return nil, &utils.NotFoundError{Entity: "Teacher"}
 		} else if rowsAffected > 0 {
 			deletedIds = append(deletedIds, id)
 		}
@@ -300,55 +356,115 @@ func DeleteTeachersDbHandler(ids []int) ([]int, error) {
 
 	if len(deletedIds) != len(ids) {
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "Error deleting teachers")
+		return nil, utils.ErrorHandler(ctx, err, "Error deleting teachers")
 	}
 
 	err = tx.Commit()
 	if err != nil {
 		tx.Rollback()
-		return nil, utils.ErrorHandler(err, "Error commiting deleted changes")
+		return nil, utils.ErrorHandler(ctx, err, "Error commiting deleted changes")
 	}
 	return deletedIds, nil
 }
 
-func GetStudentsByTeacherIdDbHandler(teacherId int, students []models.Student) ([]models.Student, error) {
+func GetStudentsByTeacherIdDbHandler(ctx context.Context, teacherId int, students []models.Student) ([]models.Student, error) {
+	ctx, span := startSpan(ctx, "GetStudentsByTeacherIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error connecting to db")
+		return nil, utils.ErrorHandler(ctx, err, "error connecting to db")
 	}
-	defer db.Close()
 
 	query := `SELECT id, first_name, last_name, email, class FROM students WHERE class = (SELECT class FROM teachers WHERE id = ?)`
-	rows, err := db.Query(query, teacherId)
+	rows, err := db.QueryContext(ctx, query, teacherId)
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "error running query")
+		return nil, utils.ErrorHandler(ctx, err, "error running query")
 	}
 
 	for rows.Next() {
 		var student models.Student
 		err = rows.Scan(&student.ID, &student.FirstName, &student.LastName, &student.Class, &student.Email)
 		if err != nil {
-			return nil, utils.ErrorHandler(err, "error scanning row")
+			return nil, utils.ErrorHandler(ctx, err, "error scanning row")
 		}
 		students = append(students, student)
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, utils.ErrorHandler(err, "rows error")
+		return nil, utils.ErrorHandler(ctx, err, "rows error")
 	}
 	return students, nil
 }
 
-func GetStudentCountByTeacherIdDbHandler(teacherId int) (int, error) {
+func GetStudentCountByTeacherIdDbHandler(ctx context.Context, teacherId int) (int, error) {
+	ctx, span := startSpan(ctx, "GetStudentCountByTeacherIdDbHandler")
+	defer span.End()
+
 	db, err := ConnectDB()
 	if err != nil {
-		return 0, utils.ErrorHandler(err, "error connecting to db")
+		return 0, utils.ErrorHandler(ctx, err, "error connecting to db")
 	}
-	defer db.Close()
 
 	query := `SELECT COUNT(*) FROM students WHERE class = (SELECT class FROM teachers WHERE id = ?)`
 
 	var studentCount int
-	err = db.QueryRow(query, teacherId).Scan(&studentCount)
+	err = db.QueryRowContext(ctx, query, teacherId).Scan(&studentCount)
 	return studentCount, err
 }
+
+// ImportTeachersDbHandler upserts teachers by email (existing teachers are updated, new ones inserted).
+// onRow is called after every teacher with the id, whether it was created and the error for that teacher.
+func ImportTeachersDbHandler(ctx context.Context, teachers []models.Teacher, onRow func(index int, id int, created bool, err error)) error {
+	ctx, span := startSpan(ctx, "ImportTeachersDbHandler")
+	defer span.End()
+
+	db, err := ConnectDB()
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error Connecting to DB")
+	}
+
+	insertStmt, err := db.PrepareContext(ctx, utils.GenerateInsertQuery("teachers", models.Teacher{}))
+	if err != nil {
+		return utils.ErrorHandler(ctx, err, "Error importing teachers")
+	}
+	defer insertStmt.Close()
+
+	for i, teacher := range teachers {
+		if ctx.Err() != nil {
+			return utils.ErrorHandler(ctx, ctx.Err(), "Import of teachers interrupted")
+		}
+
+		var existingID int
+		err = db.QueryRowContext(ctx, "SELECT id FROM teachers WHERE email = ?", teacher.Email).Scan(&existingID)
+		if err == sql.ErrNoRows {
+			res, err := insertStmt.ExecContext(ctx, utils.GetStructValues(teacher)...)
+			if err != nil {
+				if conflictErr := handleDuplicateKeyError(ctx, db, err, "teachers", "teacher", models.Teacher{}); conflictErr != nil {
+					onRow(i, 0, false, conflictErr)
+					continue
+				}
+				onRow(i, 0, false, utils.ErrorHandler(ctx, err, "Error adding teacher"))
+				continue
+			}
+			lastID, err := res.LastInsertId()
+			if err != nil {
+				onRow(i, 0, false, utils.ErrorHandler(ctx, err, "Error adding teacher"))
+				continue
+			}
+			onRow(i, int(lastID), true, nil)
+			continue
+		} else if err != nil {
+			onRow(i, 0, false, utils.ErrorHandler(ctx, err, "Error retrieving teacher"))
+			continue
+		}
+
+		_, err = db.ExecContext(ctx, "UPDATE teachers SET first_name = ?, last_name = ?, class = ?, subject = ? WHERE id = ?", teacher.FirstName, teacher.LastName, teacher.Class, teacher.Subject, existingID)
+		if err != nil {
+			onRow(i, existingID, false, utils.ErrorHandler(ctx, err, "Error updating teacher"))
+			continue
+		}
+		onRow(i, existingID, false, nil)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package sqlconnect
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("school-management/internal/repository/sqlconnect")
+
+// startSpan starts the span of a repository call, the statements run with the returned ctx are its children.
+// Errors passed to utils.ErrorHandler with that ctx mark the span as failed.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "sqlconnect."+name)
+}
@@ -0,0 +1,169 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, data string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatalf("invalid json %s: %v", data, err)
+	}
+	return v
+}
+
+func TestMergePatchRFC7396(t *testing.T) {
+	// the examples of RFC 7396 appendix A
+	tests := []struct {
+		target, patch, expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		target := decodeJSON(t, tt.target)
+		result := MergePatch(target, decodeJSON(t, tt.patch))
+		if !reflect.DeepEqual(result, decodeJSON(t, tt.expected)) {
+			t.Errorf("%s merged with %s: expected %s, got %v", tt.target, tt.patch, tt.expected, result)
+		}
+		if !reflect.DeepEqual(target, decodeJSON(t, tt.target)) {
+			t.Errorf("%s merged with %s: the target was modified", tt.target, tt.patch)
+		}
+	}
+}
+
+func TestPatchRFC6902(t *testing.T) {
+	// the examples of RFC 6902 appendix A, A.13 (duplicate members) can't be told apart by encoding/json
+	tests := []struct {
+		name, doc, patch, expected string
+		err                        error
+	}{
+		{"A.1 adding an object member", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"A.2 adding an array element", `{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"A.3 removing an object member", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"A.4 removing an array element", `{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"A.5 replacing a value", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"A.6 moving a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"A.7 moving an array element", `{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"A.8 testing a value: success", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"A.9 testing a value: error", `{"baz":"qux"}`,
+			`[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrTestFailed},
+		{"A.10 adding a nested member object", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{"A.11 ignoring unrecognized elements", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"A.12 adding to a nonexistent target", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", ErrPathNotFound},
+		{"A.14 ~ escape ordering", `{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{"A.15 comparing strings and numbers", `{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":"10"}]`, "", ErrTestFailed},
+		{"A.16 adding an array value", `{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+	}
+	runPatchTests(t, tests)
+}
+
+func TestPatchEdgeCases(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, expected string
+		err                        error
+	}{
+		{"~1 escape", `{"a/b":1}`, `[{"op":"replace","path":"/a~1b","value":2}]`, `{"a/b":2}`, nil},
+		{"~0 escape", `{"m~n":1}`, `[{"op":"remove","path":"/m~0n"}]`, `{}`, nil},
+		{"empty member name", `{"":1}`, `[{"op":"test","path":"/","value":1}]`, `{"":1}`, nil},
+		{"invalid escape", `{}`, `[{"op":"add","path":"/a~2","value":1}]`, "", ErrInvalidPatch},
+		{"pointer without slash", `{}`, `[{"op":"add","path":"a","value":1}]`, "", ErrInvalidPatch},
+		{"add at the end index", `[1,2]`, `[{"op":"add","path":"/2","value":3}]`, `[1,2,3]`, nil},
+		{"add past the end", `[1,2]`, `[{"op":"add","path":"/3","value":3}]`, "", ErrPathNotFound},
+		{"add to an empty array", `[]`, `[{"op":"add","path":"/0","value":1}]`, `[1]`, nil},
+		{"replace past the last index", `[1,2]`, `[{"op":"replace","path":"/2","value":3}]`, "", ErrPathNotFound},
+		{"remove with -", `[1,2]`, `[{"op":"remove","path":"/-"}]`, "", ErrPathNotFound},
+		{"leading zero", `[1,2]`, `[{"op":"remove","path":"/01"}]`, "", ErrPathNotFound},
+		{"negative index", `[1,2]`, `[{"op":"remove","path":"/-1"}]`, "", ErrPathNotFound},
+		{"nested arrays", `{"a":[[1],[2]]}`, `[{"op":"add","path":"/a/1/0","value":0}]`, `{"a":[[1],[0,2]]}`, nil},
+		{"replace the whole document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, nil},
+		{"remove the whole document", `{"a":1}`, `[{"op":"remove","path":""}]`, "", ErrInvalidPatch},
+		{"move into a child", `{"a":{"b":{}}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, "", ErrInvalidPatch},
+		{"move onto itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":{"b":1}}`, nil},
+		{"move to a sibling prefix", `{"a":1,"ab":2}`, `[{"op":"move","from":"/a","path":"/abc"}]`, `{"ab":2,"abc":1}`, nil},
+		{"move from a missing path", `{"a":1}`, `[{"op":"move","from":"/b","path":"/c"}]`, "", ErrPathNotFound},
+		{"copy is deep", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`, nil},
+		{"test an object", `{"a":{"x":1,"y":[1,2]}}`, `[{"op":"test","path":"/a","value":{"y":[1,2],"x":1.0}}]`, `{"a":{"x":1,"y":[1,2]}}`, nil},
+		{"test null", `{"a":null}`, `[{"op":"test","path":"/a","value":null}]`, `{"a":null}`, nil},
+		{"test a missing member", `{"a":1}`, `[{"op":"test","path":"/b","value":null}]`, "", ErrPathNotFound},
+		{"test an array order", `{"a":[1,2]}`, `[{"op":"test","path":"/a","value":[2,1]}]`, "", ErrTestFailed},
+		{"add without value", `{}`, `[{"op":"add","path":"/a"}]`, "", ErrInvalidPatch},
+		{"add null", `{}`, `[{"op":"add","path":"/a","value":null}]`, `{"a":null}`, nil},
+		{"unknown op", `{}`, `[{"op":"merge","path":"/a","value":1}]`, "", ErrInvalidPatch},
+		{"not an array", `{}`, `{"op":"add","path":"/a","value":1}`, "", ErrInvalidPatch},
+	}
+	runPatchTests(t, tests)
+}
+
+func runPatchTests(t *testing.T, tests []struct {
+	name, doc, patch, expected string
+	err                        error
+}) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := Decode([]byte(tt.patch))
+			var result any
+			if err == nil {
+				result, err = patch.Apply(decodeJSON(t, tt.doc))
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, decodeJSON(t, tt.expected)) {
+				t.Errorf("expected %s, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestPatchIsAtomic(t *testing.T) {
+	doc := decodeJSON(t, `{"a":{"b":1},"c":[1,2]}`)
+	patch, err := Decode([]byte(`[{"op":"replace","path":"/a/b","value":2},{"op":"remove","path":"/c/0"},{"op":"test","path":"/a/b","value":1}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := patch.Apply(doc); !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("expected %v, got %v", ErrTestFailed, err)
+	}
+	if !reflect.DeepEqual(doc, decodeJSON(t, `{"a":{"b":1},"c":[1,2]}`)) {
+		t.Errorf("expected the document to be left as it was, got %v", doc)
+	}
+}
@@ -0,0 +1,385 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds metrics and writes them in the Prometheus text exposition format (version 0.0.4),
+// it covers counters, gauges and histograms with labels which is all the api needs
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// ContentType of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	desc() *desc
+	// write writes the samples of the metric, without its HELP and TYPE lines
+	write(w *bufio.Writer)
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.desc().name] {
+		panic("metrics: " + m.desc().name + " is registered twice")
+	}
+	r.names[m.desc().name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all metrics sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+	slices.SortFunc(metrics, func(a, b metric) int { return strings.Compare(a.desc().name, b.desc().name) })
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		d := m.desc()
+		fmt.Fprintf(bw, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.kind)
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry to the Prometheus scraper
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		r.WriteText(w)
+	})
+}
+
+// vec keeps one child per combination of label values
+type vec[T any] struct {
+	d        desc
+	mu       sync.RWMutex
+	children map[string]*child[T]
+	newChild func() *T
+}
+
+type child[T any] struct {
+	labelValues []string
+	value       *T
+}
+
+func (v *vec[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(v.d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.d.name, len(v.d.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c.value
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok := v.children[key]; ok {
+		return c.value
+	}
+	c = &child[T]{labelValues: slices.Clone(labelValues), value: v.newChild()}
+	v.children[key] = c
+	return c.value
+}
+
+// sorted returns the children ordered by their label values, so scrapes are stable
+func (v *vec[T]) sorted() []*child[T] {
+	v.mu.RLock()
+	children := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.RUnlock()
+	slices.SortFunc(children, func(a, b *child[T]) int { return slices.Compare(a.labelValues, b.labelValues) })
+	return children
+}
+
+func (v *vec[T]) desc() *desc {
+	return &v.d
+}
+
+// atomicFloat is a float64 updated with compare and swap
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Set(value float64) {
+	f.bits.Store(math.Float64bits(value))
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// Counter only goes up
+type Counter struct {
+	value atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add panics on negative values, counters can't go down
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.value.Add(delta)
+}
+
+type CounterVec struct {
+	vec[Counter]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[Counter]{
+		d:        desc{name: name, help: help, kind: "counter", labels: labels},
+		children: make(map[string]*child[Counter]),
+		newChild: func() *Counter { return &Counter{} },
+	}}
+	r.register(v)
+	return v
+}
+
+// With returns the counter of the label values, in the order the labels were declared
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.with(labelValues)
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	for _, c := range v.sorted() {
+		writeSample(w, v.d.name, v.d.labels, c.labelValues, "", "", c.value.value.Load())
+	}
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	value atomicFloat
+}
+
+func (g *Gauge) Set(value float64) {
+	g.value.Set(value)
+}
+
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+type GaugeVec struct {
+	vec[Gauge]
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{vec[Gauge]{
+		d:        desc{name: name, help: help, kind: "gauge", labels: labels},
+		children: make(map[string]*child[Gauge]),
+		newChild: func() *Gauge { return &Gauge{} },
+	}}
+	r.register(v)
+	return v
+}
+
+// NewGauge registers a gauge without labels
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.with(labelValues)
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	for _, c := range v.sorted() {
+		writeSample(w, v.d.name, v.d.labels, c.labelValues, "", "", c.value.value.Load())
+	}
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	upperBounds []float64
+	buckets     []atomic.Uint64 // not cumulative, summed up on write
+	count       atomic.Uint64
+	sum         atomicFloat
+}
+
+func (h *Histogram) Observe(value float64) {
+	i, _ := slices.BinarySearch(h.upperBounds, value)
+	if i < len(h.buckets) {
+		h.buckets[i].Add(1)
+	}
+	h.sum.Add(value)
+	h.count.Add(1)
+}
+
+type HistogramVec struct {
+	vec[Histogram]
+	upperBounds []float64
+}
+
+// NewHistogramVec panics if buckets are not sorted, the +Inf bucket is added on its own
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	upperBounds := slices.Clone(buckets)
+	v := &HistogramVec{upperBounds: upperBounds}
+	v.vec = vec[Histogram]{
+		d:        desc{name: name, help: help, kind: "histogram", labels: labels},
+		children: make(map[string]*child[Histogram]),
+		newChild: func() *Histogram {
+			return &Histogram{upperBounds: upperBounds, buckets: make([]atomic.Uint64, len(upperBounds))}
+		},
+	}
+	r.register(v)
+	return v
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.with(labelValues)
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	for _, c := range v.sorted() {
+		h := c.value
+		// count is read first, so a concurrent Observe can't make +Inf smaller than the last bucket
+		count := h.count.Load()
+		var cumulative uint64
+		for i, bound := range v.upperBounds {
+			cumulative += h.buckets[i].Load()
+			writeSample(w, v.d.name+"_bucket", v.d.labels, c.labelValues, "le", formatFloat(bound), float64(min(cumulative, count)))
+		}
+		writeSample(w, v.d.name+"_bucket", v.d.labels, c.labelValues, "le", "+Inf", float64(count))
+		writeSample(w, v.d.name+"_sum", v.d.labels, c.labelValues, "", "", h.sum.Load())
+		writeSample(w, v.d.name+"_count", v.d.labels, c.labelValues, "", "", float64(count))
+	}
+}
+
+// funcMetric reads its value when scraped, eg. from sql.DBStats
+type funcMetric struct {
+	d  desc
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is fn's result at scrape time
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{d: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is fn's result at scrape time, fn must never decrease
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{d: desc{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (m *funcMetric) desc() *desc {
+	return &m.d
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	writeSample(w, m.d.name, nil, nil, "", "", m.fn())
+}
+
+// writeSample writes one line, extraLabel is the le label of histogram buckets
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label)
+			w.WriteString(`="`)
+			w.WriteString(escapeLabelValue(labelValues[i]))
+			w.WriteByte('"')
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel)
+			w.WriteString(`="`)
+			w.WriteString(extraValue)
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
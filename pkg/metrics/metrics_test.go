@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func writeText(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestWriteTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests by method.", "method", "status")
+	r.NewGauge("in_flight", "Requests in flight.").Set(3)
+	r.NewGaugeFunc("connections", "Open connections.", func() float64 { return 2 })
+	requests.With("POST", "201").Inc()
+	requests.With("GET", "200").Add(2.5)
+
+	expected := `# HELP connections Open connections.
+# TYPE connections gauge
+connections 2
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 3
+# HELP requests_total Requests by method.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 2.5
+requests_total{method="POST",status="201"} 1
+`
+	if got := writeText(t, r); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestWriteTextHistogram(t *testing.T) {
+	r := NewRegistry()
+	latency := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 0.5, 1}, "route")
+	h := latency.With("/students")
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 4} {
+		h.Observe(v)
+	}
+
+	// buckets are cumulative and include their upper bound
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/students",le="0.1"} 2
+latency_seconds_bucket{route="/students",le="0.5"} 3
+latency_seconds_bucket{route="/students",le="1"} 4
+latency_seconds_bucket{route="/students",le="+Inf"} 5
+latency_seconds_sum{route="/students"} 5.15
+latency_seconds_count{route="/students"} 5
+`
+	if got := writeText(t, r); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestWriteTextEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("escaped_total", "A \\ backslash\nand a newline, \"quotes\" stay.", "path").With("a\\b\"c\nd").Inc()
+
+	expected := `# HELP escaped_total A \\ backslash\nand a newline, "quotes" stay.
+# TYPE escaped_total counter
+escaped_total{path="a\\b\"c\nd"} 1
+`
+	if got := writeText(t, r); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("dup", "Duplicate.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	r.NewGauge("dup", "Duplicate.")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("up", "Up.").Set(1)
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected content type %q, got %q", ContentType, ct)
+	}
+	if !strings.Contains(w.Body.String(), "\nup 1\n") {
+		t.Errorf("expected the up sample, got\n%s", w.Body.String())
+	}
+}
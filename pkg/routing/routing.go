@@ -0,0 +1,268 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"school-management/pkg/utils"
+	"slices"
+	"strings"
+)
+
+// Router is a thin layer over http.ServeMux adding route groups with prefixes and middleware stacks,
+// named routes for building urls, json 404/405 responses and automatic OPTIONS handling
+type Router struct {
+	mux    *http.ServeMux
+	routes []*Route
+	named  map[string]*Route
+	root   *Group
+}
+
+// Route is a registered route, Meta holds the values set on its groups (see Group.Set)
+type Route struct {
+	Name         string
+	Method       string
+	Path         string // full path including all group prefixes
+	LocalPattern string // pattern as passed to Handle, relative to its group
+	Handler      http.Handler
+	Meta         map[string]interface{}
+
+	group *Group
+}
+
+// Group registers routes under a path prefix, wrapped by the middlewares of the group and all its parents
+type Group struct {
+	router      *Router
+	parent      *Group
+	prefix      string
+	namePrefix  string
+	middlewares []utils.Middleware
+	meta        map[string]interface{}
+}
+
+func New() *Router {
+	r := &Router{mux: http.NewServeMux(), named: make(map[string]*Route)}
+	r.root = &Group{router: r, meta: make(map[string]interface{})}
+	return r
+}
+
+// Root is the group without prefix or middlewares
+func (r *Router) Root() *Group {
+	return r.root
+}
+
+// Group creates a group below the root group
+func (r *Router) Group(prefix string, middlewares ...utils.Middleware) *Group {
+	return r.root.Group(prefix, middlewares...)
+}
+
+// Group creates a sub group, its prefix is appended to the parent's and its middlewares run inside the parent's
+func (g *Group) Group(prefix string, middlewares ...utils.Middleware) *Group {
+	return &Group{
+		router:      g.router,
+		parent:      g,
+		prefix:      g.prefix + strings.TrimSuffix(prefix, "/"),
+		namePrefix:  g.namePrefix,
+		middlewares: middlewares,
+		meta:        make(map[string]interface{}),
+	}
+}
+
+// Use adds middlewares to the group, they are applied like utils.ApplyMiddlewares (the last one runs first).
+// Only routes registered after the call are affected.
+func (g *Group) Use(middlewares ...utils.Middleware) *Group {
+	g.middlewares = append(g.middlewares, middlewares...)
+	return g
+}
+
+// Named prefixes the names of routes registered on the group (and its sub groups), eg. "v1." + "students.list"
+func (g *Group) Named(namePrefix string) *Group {
+	g.namePrefix = namePrefix
+	return g
+}
+
+// Set stores a value copied into the Meta of every route registered on the group (and its sub groups)
+func (g *Group) Set(key string, value interface{}) *Group {
+	g.meta[key] = value
+	return g
+}
+
+// Prefix is the full path prefix of the group
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+// Handle registers a ServeMux pattern ("GET /students/{id}") relative to the group prefix
+func (g *Group) Handle(pattern string, handler http.Handler) *Route {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("routing: pattern %q needs a method and a path", pattern))
+	}
+
+	route := &Route{
+		Method:       method,
+		Path:         g.prefix + path,
+		LocalPattern: pattern,
+		Handler:      handler,
+		Meta:         make(map[string]interface{}),
+	}
+
+	// middlewares of the innermost group are applied first so the ones of parent groups run before them
+	wrapped := handler
+	for group := g; group != nil; group = group.parent {
+		wrapped = utils.ApplyMiddlewares(wrapped, group.middlewares...)
+	}
+	for group := g; group != nil; group = group.parent {
+		for key, value := range group.meta {
+			if _, ok := route.Meta[key]; !ok {
+				route.Meta[key] = value
+			}
+		}
+	}
+
+	g.router.mux.Handle(route.Method+" "+route.Path, wrapped)
+	g.router.routes = append(g.router.routes, route)
+	route.group = g
+	return route
+}
+
+func (g *Group) HandleFunc(pattern string, handler http.HandlerFunc) *Route {
+	return g.Handle(pattern, handler)
+}
+
+// Named gives the route a name (prefixed with the group's name prefix) to build its url with Router.URL
+func (rt *Route) Named(name string) *Route {
+	router := rt.group.router
+	rt.Name = rt.group.namePrefix + name
+	if _, ok := router.named[rt.Name]; ok {
+		panic(fmt.Sprintf("routing: route name %q is already used", rt.Name))
+	}
+	router.named[rt.Name] = rt
+	return rt
+}
+
+// Set stores a value in the Meta of the route, it wins over a value set on its groups
+func (rt *Route) Set(key string, value interface{}) *Route {
+	rt.Meta[key] = value
+	return rt
+}
+
+// Routes returns all routes in registration order
+func (r *Router) Routes() []*Route {
+	return r.routes
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// URL builds the path of a named route, params are name/value pairs: URL("v1.students.show", "id", "42")
+func (r *Router) URL(name string, params ...string) (string, error) {
+	route, ok := r.named[name]
+	if !ok {
+		return "", fmt.Errorf("routing: no route named %q", name)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("routing: params of %q must be name/value pairs", name)
+	}
+
+	values := make(map[string]string)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	var missing []string
+	path := pathParamPattern.ReplaceAllStringFunc(route.Path, func(segment string) string {
+		param := pathParamPattern.FindStringSubmatch(segment)
+		value, ok := values[param[1]]
+		if !ok {
+			missing = append(missing, param[1])
+			return segment
+		}
+		if param[2] != "" {
+			return value // {path...} keeps its slashes
+		}
+		return strings.ReplaceAll(value, "/", "%2F")
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("routing: missing params %s for %q", strings.Join(missing, ", "), name)
+	}
+	return path, nil
+}
+
+var probeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	_, pattern := r.mux.Handler(req)
+	if pattern != "" {
+		r.mux.ServeHTTP(w, req)
+		return
+	}
+
+	allowed := r.allowedMethods(req)
+	if len(allowed) > 0 {
+		if req.Method == http.MethodOptions {
+			w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed, use %s", req.Method, strings.Join(allowed, ", ")), utils.RequestID(req.Context()))
+		return
+	}
+
+	// let the mux answer (it redirects unclean paths), only its plain text 404 is replaced
+	r.mux.ServeHTTP(&notFoundWriter{ResponseWriter: w, requestID: utils.RequestID(req.Context())}, req)
+}
+
+// allowedMethods lists the methods registered for the request path
+func (r *Router) allowedMethods(req *http.Request) []string {
+	var allowed []string
+	for _, method := range probeMethods {
+		probe := req.Clone(req.Context())
+		probe.Method = method
+		if _, pattern := r.mux.Handler(probe); pattern != "" && !slices.Contains(allowed, method) {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// notFoundWriter turns the plain text 404 of http.ServeMux into a json response
+type notFoundWriter struct {
+	http.ResponseWriter
+	requestID string
+	notFound  bool
+}
+
+func (nw *notFoundWriter) WriteHeader(status int) {
+	if status == http.StatusNotFound {
+		nw.notFound = true
+		writeJSONError(nw.ResponseWriter, http.StatusNotFound, "route not found", nw.requestID)
+		return
+	}
+	nw.ResponseWriter.WriteHeader(status)
+}
+
+func (nw *notFoundWriter) Write(b []byte) (int, error) {
+	if nw.notFound {
+		return len(b), nil
+	}
+	return nw.ResponseWriter.Write(b)
+}
+
+// writeJSONError sends an error payload, requestID lets clients quote the failing request (omitted when empty)
+func writeJSONError(w http.ResponseWriter, status int, message, requestID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	response := struct {
+		Status    string `json:"status"`
+		Error     string `json:"error"`
+		RequestID string `json:"request_id,omitempty"`
+	}{
+		Status:    "error",
+		Error:     message,
+		RequestID: requestID,
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,12 @@
+package utils
+
+import "net/http"
+
+// VerifiedClientName returns the common name of the client certificate verified during the tls handshake,
+// only trusted internal services have a certificate signed by tls.client_ca_file
+func VerifiedClientName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
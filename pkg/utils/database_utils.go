@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"slices"
 	"strings"
 )
 
@@ -11,15 +12,34 @@ func isValidSortOrder(order string) bool {
 	return order == "asc" || order == "desc"
 }
 
-func isValidSortField(field string) bool {
-	validFields := map[string]bool{
-		"first_name": true,
-		"last_name":  true,
-		"email":      true,
-		"class":      true,
-		"subject":    true,
+// SortFields are the fields accepted by ?sortby=field:order on list endpoints
+var SortFields = []string{"first_name", "last_name", "email", "class", "subject"}
+
+// FilterFields maps the filter query params of list endpoints to their db column
+var FilterFields = map[string]string{
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"email":      "email",
+	"subject":    "subject",
+	"class":      "class",
+}
+
+// FilterFieldsOf returns the filter query params that are json fields of model, sorted
+func FilterFieldsOf(model interface{}) []string {
+	modelType := reflect.TypeOf(model)
+	var fields []string
+	for i := 0; i < modelType.NumField(); i++ {
+		field := strings.TrimSuffix(modelType.Field(i).Tag.Get("json"), ",omitempty")
+		if _, ok := FilterFields[field]; ok {
+			fields = append(fields, field)
+		}
 	}
-	return validFields[field]
+	slices.Sort(fields)
+	return fields
+}
+
+func isValidSortField(field string) bool {
+	return slices.Contains(SortFields, field)
 }
 
 func AddSorting(r *http.Request, query string) string {
@@ -46,15 +66,7 @@ func AddSorting(r *http.Request, query string) string {
 }
 
 func AddFilters(r *http.Request, query string, args []interface{}) (string, []interface{}) {
-	params := map[string]string{
-		"first_name": "first_name",
-		"last_name":  "last_name",
-		"email":      "email",
-		"subject":    "subject",
-		"class":      "class",
-	}
-
-	for param, dbField := range params {
+	for param, dbField := range FilterFields {
 		value := r.URL.Query().Get(param)
 		if value != "" {
 			query += " AND " + dbField + " = ?"
@@ -65,13 +77,28 @@ func AddFilters(r *http.Request, query string, args []interface{}) (string, []in
 }
 
 func GenerateInsertQuery(tableName string, model interface{}) string {
+	columns, placeholders := insertColumns(model)
+	return fmt.Sprintf("INSERT INTO %s (%s) Values (%s)", tableName, columns, placeholders)
+}
+
+// GenerateBulkInsertQuery builds a single multi-row insert - INSERT INTO table (a, b) Values (?, ?), (?, ?)
+func GenerateBulkInsertQuery(tableName string, model interface{}, rowCount int) string {
+	columns, placeholders := insertColumns(model)
+
+	rows := make([]string, rowCount)
+	for i := range rows {
+		rows[i] = "(" + placeholders + ")"
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) Values %s", tableName, columns, strings.Join(rows, ", "))
+}
+
+func insertColumns(model interface{}) (string, string) {
 	modelType := reflect.TypeOf(model)
 	var columns, placeholders string
 
 	for i := 0; i < modelType.NumField(); i++ {
 		dbTag := modelType.Field(i).Tag.Get("db")
 		dbTag = strings.TrimSuffix(dbTag, ",omitempty")
-		fmt.Println("dbTag", dbTag)
 
 		if dbTag != "" && dbTag != "id" { // skip id field if its auto increment
 			if columns != "" {
@@ -82,7 +109,7 @@ func GenerateInsertQuery(tableName string, model interface{}) string {
 			placeholders += "?"
 		}
 	}
-	return fmt.Sprintf("INSERT INTO %s (%s) Values (%s)", tableName, columns, placeholders)
+	return columns, placeholders
 }
 
 func GetStructValues(model interface{}) []interface{} {
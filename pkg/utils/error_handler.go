@@ -1,13 +1,52 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"path/filepath"
+	"runtime"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ErrorHandler(err error, message string) error {
-	errorLogger := log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-	errorLogger.Println(message, err)
-	return fmt.Errorf(message)
+// ErrorHandler logs err with the logger of ctx and returns an error carrying only the message, which is safe to show to clients
+func ErrorHandler(ctx context.Context, err error, message string) error {
+	logger := Logger(ctx)
+	if _, file, line, ok := runtime.Caller(1); ok {
+		logger = logger.With("source", fmt.Sprintf("%s:%d", filepath.Base(file), line))
+	}
+	logger.Error(message, "error", err)
+
+	// the span of the failing call (eg. a repository call) is marked as failed
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, message)
+	}
+	return errors.New(message)
+}
+
+// ConflictError is returned when a write violates a unique constraint (eg, email already used by another teacher)
+type ConflictError struct {
+	Entity     string
+	Field      string
+	Value      string
+	ExistingID int
+}
+
+func (e *ConflictError) Error() string {
+	if e.ExistingID > 0 {
+		return fmt.Sprintf("%s '%s' already used by %s %d", e.Field, e.Value, e.Entity, e.ExistingID)
+	}
+	return fmt.Sprintf("%s '%s' already used by another %s", e.Field, e.Value, e.Entity)
+}
+
+// NotFoundError is returned when the requested row doesn't exist (eg. an unknown id)
+type NotFoundError struct {
+	Entity string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Entity + " not found"
 }
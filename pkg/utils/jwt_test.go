@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1700000000, 0)
+	token, err := SignToken(TokenClaims{Subject: "7", Role: "admin", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := VerifyToken(token, secret, now)
+	if err != nil || claims.Subject != "7" || claims.Role != "admin" {
+		t.Fatalf("expected the signed claims, got %+v, %v", claims, err)
+	}
+
+	parts := strings.Split(token, ".")
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iat":0,"exp":9999999999}`)) + "." + parts[2]
+
+	tests := []struct {
+		name   string
+		token  string
+		secret []byte
+		now    time.Time
+		err    error
+	}{
+		{"expired", token, secret, now.Add(time.Minute), ErrTokenExpired},
+		{"other secret", token, []byte("another secret of at least 32 bytes"), now, ErrInvalidToken},
+		{"forged claims", forged, secret, now, ErrInvalidToken},
+		{"alg none", unsigned, secret, now, ErrInvalidToken},
+		{"garbage", "abc", secret, now, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		_, err := VerifyToken(tt.token, tt.secret, tt.now)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
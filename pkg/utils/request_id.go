@@ -0,0 +1,19 @@
+package utils
+
+import "context"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID stores the id correlating the logs, errors and queries of a request
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request id stored in ctx, or "" outside of requests
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// minimal reader for .xlsx (Office Open XML) workbooks - only reads cell values of the first worksheet
+
+// MaxXLSXPartSize caps the uncompressed size of every part read from a workbook (the sheet, its shared strings),
+// the upload limit only covers the compressed file and a small zip bomb would inflate to gigabytes
+const MaxXLSXPartSize = 64 << 20
+
+var ErrXLSXTooLarge = errors.New("xlsx file is too large once uncompressed, every part is limited to 64 MB")
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxRichText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (rt xlsxRichText) String() string {
+	if len(rt.Runs) == 0 {
+		return rt.Text
+	}
+	var sb strings.Builder
+	for _, run := range rt.Runs {
+		sb.WriteString(run.Text)
+	}
+	return sb.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxRichText `xml:"si"`
+}
+
+type xlsxCell struct {
+	Ref       string       `xml:"r,attr"`
+	Type      string       `xml:"t,attr"`
+	Value     string       `xml:"v"`
+	InlineStr xlsxRichText `xml:"is"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []xlsxCell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// ReadXLSXRows returns the cell values of the first worksheet row by row
+func ReadXLSXRows(r io.ReaderAt, size int64) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.New("invalid xlsx file")
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var sharedStrings xlsxSharedStrings
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		err = decodeZipXML(f, &sharedStrings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sheetFile, ok := files[firstSheetPath(files)]
+	if !ok {
+		return nil, errors.New("xlsx file has no worksheet")
+	}
+
+	var sheet xlsxWorksheet
+	err = decodeZipXML(sheetFile, &sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		var values []string
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				col = xlsxColumnIndex(cell.Ref)
+			}
+			for len(values) <= col {
+				values = append(values, "")
+			}
+
+			switch cell.Type {
+			case "s":
+				idx, err := strconv.Atoi(cell.Value)
+				if err != nil || idx < 0 || idx >= len(sharedStrings.Items) {
+					return nil, errors.New("invalid shared string reference in xlsx file")
+				}
+				values[col] = sharedStrings.Items[idx].String()
+			case "inlineStr":
+				values[col] = cell.InlineStr.String()
+			default:
+				values[col] = cell.Value
+			}
+		}
+		rows = append(rows, values)
+	}
+	return rows, nil
+}
+
+// firstSheetPath resolves the part name of the first sheet listed in the workbook
+func firstSheetPath(files map[string]*zip.File) string {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var workbook xlsxWorkbook
+	var rels xlsxRelationships
+	wbFile, ok := files["xl/workbook.xml"]
+	relsFile, relsOk := files["xl/_rels/workbook.xml.rels"]
+	if !ok || !relsOk || decodeZipXML(wbFile, &workbook) != nil || decodeZipXML(relsFile, &rels) != nil || len(workbook.Sheets) == 0 {
+		return fallback
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID == workbook.Sheets[0].RID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/")
+			}
+			return path.Join("xl", rel.Target)
+		}
+	}
+	return fallback
+}
+
+// xlsxColumnIndex converts a cell reference like "C12" to its zero based column index (2)
+func xlsxColumnIndex(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	if f.UncompressedSize64 > MaxXLSXPartSize {
+		return ErrXLSXTooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return errors.New("invalid xlsx file")
+	}
+	defer rc.Close()
+
+	// the size in the zip header is written by the client, the reader stops at the limit whatever it claims
+	limited := &io.LimitedReader{R: rc, N: MaxXLSXPartSize + 1}
+	err = xml.NewDecoder(limited).Decode(v)
+	if limited.N <= 0 {
+		return ErrXLSXTooLarge
+	}
+	if err != nil {
+		return errors.New("invalid xlsx file")
+	}
+	return nil
+}
+
+// XLSXStreamWriter writes a single sheet .xlsx workbook row by row straight to w, nothing is buffered besides the current row
+type XLSXStreamWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	rows  int
+}
+
+const (
+	xlsxContentTypesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRootRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxWorkbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+func NewXLSXStreamWriter(w io.Writer, sheetName string) (*XLSXStreamWriter, error) {
+	zw := zip.NewWriter(w)
+
+	var escapedName strings.Builder
+	xml.EscapeText(&escapedName, []byte(sheetName))
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypesXML},
+		{"_rels/.rels", xlsxRootRelsXML},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRelsXML},
+		{"xl/workbook.xml", strings.Replace(xlsxWorkbookXML, "%s", escapedName.String(), 1)},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(f, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the sheet has to be the last entry, it stays open while rows are streamed into it
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(sheet, xlsxSheetStart)
+	if err != nil {
+		return nil, err
+	}
+	return &XLSXStreamWriter{zw: zw, sheet: sheet}, nil
+}
+
+// WriteRow appends a row of inline string cells
+func (x *XLSXStreamWriter) WriteRow(values []string) error {
+	x.rows++
+
+	var sb strings.Builder
+	sb.WriteString(`<row r="` + strconv.Itoa(x.rows) + `">`)
+	for _, value := range values {
+		sb.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(&sb, []byte(value))
+		sb.WriteString(`</t></is></c>`)
+	}
+	sb.WriteString(`</row>`)
+
+	_, err := io.WriteString(x.sheet, sb.String())
+	return err
+}
+
+// Flush pushes the compressed data written so far to the underlying writer
+func (x *XLSXStreamWriter) Flush() error {
+	return x.zw.Flush()
+}
+
+// Close finishes the sheet and writes the zip central directory, it does not close the underlying writer
+func (x *XLSXStreamWriter) Close() error {
+	_, err := io.WriteString(x.sheet, xlsxSheetEnd)
+	if err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestXLSXRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewXLSXStreamWriter(&buf, "students")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]string{{"first_name", "email"}, {"Jane <&>", "jane@example.com"}}
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadXLSXRows(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(read, rows, slices.Equal) {
+		t.Errorf("expected %v, got %v", rows, read)
+	}
+}
+
+func TestReadXLSXRowsRefusesZipBombs(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// compresses to a few hundred kB
+	sheet.Write([]byte(`<worksheet><sheetData><row><c t="inlineStr"><is><t>`))
+	chunk := []byte(strings.Repeat("a", 1<<20))
+	for i := 0; i <= MaxXLSXPartSize>>20; i++ {
+		sheet.Write(chunk)
+	}
+	sheet.Write([]byte(`</t></is></c></row></sheetData></worksheet>`))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ReadXLSXRows(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !errors.Is(err, ErrXLSXTooLarge) {
+		t.Errorf("expected ErrXLSXTooLarge for a %d kB upload, got %v", buf.Len()>>10, err)
+	}
+}